@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"go-transcoder/config"
+	"go-transcoder/service"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// runCLI transcodes a single file in the foreground, without the HTTP API or
+// a queue, and returns the process exit code
+func runCLI(cfg *config.Config, presets *service.PresetCatalog) int {
+	if err := transcodeFile(cfg, presets); err != nil {
+		slog.Error("Transcoding failed", "input", cfg.CLI.Input, "error", err)
+		return 1
+	}
+	return 0
+}
+
+func transcodeFile(cfg *config.Config, presets *service.PresetCatalog) error {
+	opts := cfg.CLI
+
+	format, err := service.ParseOutputFormat(cfg.Job.OutputFormat)
+	if err != nil {
+		return err
+	}
+	segmentFormat, err := service.ParseSegmentFormat(cfg.Job.SegmentFormat)
+	if err != nil {
+		return err
+	}
+	preset, err := presets.Get(cfg.Job.Preset)
+	if err != nil {
+		return err
+	}
+	if err := preset.CheckSegmentFormat(segmentFormat); err != nil {
+		return err
+	}
+	if cfg.Job.Encrypt {
+		if err := format.CheckEncryption(); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(opts.Input)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", opts.Input)
+	}
+
+	transcodeOpts := preset.TranscodeOptions(segmentFormat)
+	if cfg.Job.Encrypt {
+		transcodeOpts.Encryption = &service.Encryption{KeyURL: cfg.Encryption.KeyURL, RotateEvery: cfg.Encryption.RotateEvery}
+	}
+	for _, subtitle := range opts.Subtitles {
+		if !service.IsSubtitleFile(subtitle) {
+			return fmt.Errorf("unsupported subtitle file %s", subtitle)
+		}
+		if _, err := os.Stat(subtitle); err != nil {
+			return err
+		}
+		transcodeOpts.Subtitles = append(transcodeOpts.Subtitles, service.SubtitleFromFilename(subtitle, filepath.Base(subtitle)))
+	}
+
+	// The transcoder writes below <output root>/<video ID>, so the requested
+	// directory is split into the two
+	outputDir := opts.Output
+	if outputDir == "" {
+		outputDir = filepath.Join(cfg.Storage.OutputDir, outputName(opts.Input))
+	}
+	outputDir = filepath.Clean(outputDir)
+	videoID := filepath.Base(outputDir)
+	if err := service.ValidateID(videoID); err != nil {
+		return fmt.Errorf("unsupported output directory name: %v", err)
+	}
+
+	cliCfg := *cfg
+	cliCfg.Storage.OutputDir = filepath.Dir(outputDir)
+	services := service.InitService(&cliCfg, presets)
+
+	duration, err := services.ProgressUI.GetDuration(opts.Input)
+	if err != nil {
+		return err
+	}
+	_, height, _, err := services.Transcode.GetVariantMetadata(opts.Input)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Transcoding", "input", opts.Input, "output", outputDir, "preset", preset.Name, "format", format)
+	tracker := service.NewProgressTracker("")
+	results, err := services.Transcode.StartTranscoding(tracker, opts.Input, videoID, preset.RungsFor(height), duration, transcodeOpts)
+	if err != nil {
+		return err
+	}
+
+	variants := service.CollectVariants(results)
+	if format.HasHLS() {
+		if err := services.Transcode.GenerateMasterPlaylist(videoID, variants); err != nil {
+			return err
+		}
+		fmt.Println(filepath.Join(outputDir, "master.m3u8"))
+	}
+	if format.HasDASH() {
+		if err := services.Transcode.GenerateDashManifest(videoID, variants); err != nil {
+			return err
+		}
+		fmt.Println(filepath.Join(outputDir, "manifest.mpd"))
+	}
+
+	if _, err := services.Transcode.GenerateImages(videoID, opts.Input, duration); err != nil {
+		slog.Warn("Failed to generate images", "error", err)
+	}
+	if preset.Trickplay != nil {
+		if _, err := services.Transcode.GenerateTrickplay(videoID, opts.Input, duration, *preset.Trickplay); err != nil {
+			slog.Warn("Failed to generate trickplay sprites", "error", err)
+		}
+	}
+	return nil
+}
+
+// outputName derives a directory name from the input file name, falling back
+// to a UUID for names without a usable slug
+func outputName(input string) string {
+	if name := service.Slugify(strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))); name != "" {
+		return name
+	}
+	return uuid.New().String()
+}
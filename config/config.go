@@ -0,0 +1,462 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every runtime setting of the API and the worker
+type Config struct {
+	Mode        string
+	HTTP        HTTPConfig
+	Queue       QueueConfig
+	Kafka       KafkaConfig
+	Storage     StorageConfig
+	Encryption  EncryptionConfig
+	Playback    PlaybackConfig
+	Auth        AuthConfig
+	Quota       QuotaConfig
+	Ingest      IngestConfig
+	Job         JobOptions
+	CLI         CLIConfig
+	Watch       WatchConfig
+	MaxUploadMB int64
+	PresetsPath string
+}
+
+type HTTPConfig struct {
+	Addr string
+}
+
+// QueueConfig selects the job queue backend and names its topics
+type QueueConfig struct {
+	Backend       string // kafka, memory or file
+	Dir           string // Root of the file backend
+	JobsTopic     string
+	ProgressTopic string
+	DLQTopic      string
+	GroupID       string
+	MaxAttempts   int           // Attempts per job, including the first
+	RetryBackoff  time.Duration // Delay before the first retry, doubled for each further one
+	MaxJobTime    time.Duration // Longest a worker may hold a job before the queue hands it to another
+}
+
+type KafkaConfig struct {
+	Brokers []string
+}
+
+// StorageConfig names the directories shared by the API and the worker
+type StorageConfig struct {
+	UploadsDir string
+	OutputDir  string
+	JobsDir    string
+	KeysDir    string // Content keys of encrypted videos, never served from OutputDir
+}
+
+// AuthConfig selects how API callers authenticate. The API is open while
+// neither API keys nor a JWT key are configured.
+type AuthConfig struct {
+	APIKeys      []string // "<tenant>:<key>" pairs
+	JWTSecret    string   // HS256 shared secret
+	JWTPublicKey string   // Path to a PEM encoded RS256 public key
+	JWTIssuer    string   // Required iss claim, unchecked when empty
+	JWTAudience  string   // Required aud claim, unchecked when empty
+	TenantClaim  string   // JWT claim holding the caller's tenant
+}
+
+// Enabled reports whether API requests must be authenticated
+func (a AuthConfig) Enabled() bool {
+	return len(a.APIKeys) > 0 || a.JWTSecret != "" || a.JWTPublicKey != ""
+}
+
+// QuotaConfig limits what each tenant may consume. Zero disables a limit.
+type QuotaConfig struct {
+	MaxActiveJobs     int     // Jobs of a tenant that may be queued or running at once
+	MaxStorageMB      int64   // Size of a tenant's output directory
+	MaxMonthlyMinutes int     // Minutes of source video a tenant may submit per calendar month (UTC)
+	UploadRate        float64 // Uploads per minute per API key, JWT subject or, without authentication, client address
+	UploadBurst       int     // Uploads a caller may send at once before UploadRate applies
+}
+
+// Enabled reports whether any tenant quota is set, not counting the upload rate
+func (q QuotaConfig) Enabled() bool {
+	return q.MaxActiveJobs > 0 || q.MaxStorageMB > 0 || q.MaxMonthlyMinutes > 0
+}
+
+// PlaybackConfig controls the signed URLs /videos/ is served under
+type PlaybackConfig struct {
+	Secret string        // HMAC secret of playback tokens; /videos/ is public when empty
+	TTL    time.Duration // Lifetime of issued URLs
+	BindIP bool          // Restrict issued URLs to the address of the client they were issued to
+}
+
+// EncryptionConfig tunes AES-128 segment encryption and the key endpoint
+type EncryptionConfig struct {
+	KeyURL      string // Base URL of the key endpoint written into playlists
+	RotateEvery int    // Segments per key; 0 encrypts a whole video with one key
+	TokenSecret string // HMAC secret playback tokens are signed with; keys are never released without it
+}
+
+type IngestConfig struct {
+	AllowedHosts []string
+	Timeout      time.Duration
+}
+
+// JobOptions apply to the jobs created by modes cli and watch
+type JobOptions struct {
+	Preset        string
+	OutputFormat  string
+	SegmentFormat string
+	Encrypt       bool
+}
+
+// CLIConfig describes the single file transcoded by mode cli
+type CLIConfig struct {
+	Input     string
+	Output    string   // Directory that receives the manifests and renditions
+	Subtitles []string // Sidecar subtitle files, named like movie.en.srt
+}
+
+// WatchConfig describes the drop directory polled by mode watch
+type WatchConfig struct {
+	Dir          string
+	PollInterval time.Duration
+	StableFor    time.Duration // How long a file's size must stay unchanged before it is ingested
+	Tenant       string        // Tenant the ingested videos belong to; empty for none
+}
+
+// envPrefix namespaces the environment variables read by Load
+const envPrefix = "TRANSCODER_"
+
+// envAliases are unprefixed variables accepted for compatibility with existing deployments
+var envAliases = map[string]string{
+	"kafka-brokers": "KAFKA_BROKERS",
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() Config {
+	return Config{
+		Mode: "all",
+		HTTP: HTTPConfig{Addr: ":8080"},
+		Queue: QueueConfig{
+			Backend:       "kafka",
+			Dir:           "queue",
+			JobsTopic:     "transcoding-jobs",
+			ProgressTopic: "transcoding-progress",
+			DLQTopic:      "transcoding-jobs-dlq",
+			GroupID:       "transcoder-group",
+			MaxAttempts:   4,
+			RetryBackoff:  30 * time.Second,
+			MaxJobTime:    6 * time.Hour,
+		},
+		Kafka: KafkaConfig{Brokers: []string{"localhost:9092"}},
+		Storage: StorageConfig{
+			UploadsDir: "uploads",
+			OutputDir:  "output",
+			JobsDir:    "jobs",
+			KeysDir:    "keys",
+		},
+		Encryption: EncryptionConfig{KeyURL: "/keys"},
+		Playback:   PlaybackConfig{TTL: time.Hour},
+		Auth:       AuthConfig{TenantClaim: "tenant"},
+		Quota:      QuotaConfig{UploadBurst: 5},
+		Ingest:     IngestConfig{Timeout: 30 * time.Minute},
+		Watch: WatchConfig{
+			Dir:          "watch",
+			PollInterval: 2 * time.Second,
+			StableFor:    10 * time.Second,
+		},
+		MaxUploadMB: 8192,
+		PresetsPath: "presets.json",
+	}
+}
+
+// MaxUploadSize returns the upload limit in bytes
+func (c *Config) MaxUploadSize() int64 {
+	return c.MaxUploadMB << 20
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, an optional JSON file, TRANSCODER_* environment variables
+// and command-line flags. Every setting is named after its flag: the file
+// uses the flag name as key and the environment variable is the flag name
+// upper-cased, with dashes replaced by underscores, behind envPrefix.
+func Load(name string, args []string) (*Config, error) {
+	cfg := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "Path to an optional JSON config file")
+	fs.StringVar(&cfg.Mode, "mode", cfg.Mode, "Mode to run the app in: api, worker, all, cli or watch")
+	fs.StringVar(&cfg.HTTP.Addr, "http-addr", cfg.HTTP.Addr, "Address the API listens on")
+	fs.StringVar(&cfg.Queue.Backend, "queue", cfg.Queue.Backend, "Job queue backend: kafka, memory or file")
+	fs.StringVar(&cfg.Queue.Dir, "queue-dir", cfg.Queue.Dir, "Directory of the file queue backend")
+	fs.StringVar(&cfg.Queue.JobsTopic, "jobs-topic", cfg.Queue.JobsTopic, "Topic transcoding jobs are queued on")
+	fs.StringVar(&cfg.Queue.ProgressTopic, "progress-topic", cfg.Queue.ProgressTopic, "Topic job state and progress events are published on")
+	fs.StringVar(&cfg.Queue.DLQTopic, "dlq-topic", cfg.Queue.DLQTopic, "Topic jobs are moved to once they cannot be retried")
+	fs.StringVar(&cfg.Queue.GroupID, "group-id", cfg.Queue.GroupID, "Consumer group shared by the workers")
+	fs.IntVar(&cfg.Queue.MaxAttempts, "max-attempts", cfg.Queue.MaxAttempts, "Attempts per job before it is dead-lettered")
+	fs.DurationVar(&cfg.Queue.RetryBackoff, "retry-backoff", cfg.Queue.RetryBackoff, "Delay before the first retry, doubled for each further one")
+	fs.DurationVar(&cfg.Queue.MaxJobTime, "max-job-time", cfg.Queue.MaxJobTime, "Longest a worker may spend on one job before it is redelivered to another")
+	fs.Var((*listValue)(&cfg.Kafka.Brokers), "kafka-brokers", "Comma-separated Kafka bootstrap servers")
+	fs.StringVar(&cfg.Storage.UploadsDir, "uploads-dir", cfg.Storage.UploadsDir, "Directory source videos are stored in")
+	fs.StringVar(&cfg.Storage.OutputDir, "output-dir", cfg.Storage.OutputDir, "Directory renditions and manifests are written to")
+	fs.StringVar(&cfg.Storage.JobsDir, "jobs-dir", cfg.Storage.JobsDir, "Directory job records are stored in")
+	fs.StringVar(&cfg.Storage.KeysDir, "keys-dir", cfg.Storage.KeysDir, "Directory the content keys of encrypted videos are stored in")
+	fs.StringVar(&cfg.Encryption.KeyURL, "key-url", cfg.Encryption.KeyURL, "Base URL of the key endpoint referenced by encrypted playlists")
+	fs.IntVar(&cfg.Encryption.RotateEvery, "key-rotation", cfg.Encryption.RotateEvery, "Segments encrypted with each key (0: one key per video)")
+	fs.StringVar(&cfg.Encryption.TokenSecret, "key-secret", cfg.Encryption.TokenSecret, "Secret playback tokens for the key endpoint are signed with")
+	fs.StringVar(&cfg.Playback.Secret, "playback-secret", cfg.Playback.Secret, "Secret playback URLs are signed with (default: /videos/ is public)")
+	fs.DurationVar(&cfg.Playback.TTL, "playback-ttl", cfg.Playback.TTL, "Lifetime of signed playback URLs")
+	fs.BoolVar(&cfg.Playback.BindIP, "playback-bind-ip", cfg.Playback.BindIP, "Bind signed playback URLs to the requesting client's IP address")
+	fs.Var((*listValue)(&cfg.Auth.APIKeys), "api-keys", "Comma-separated <tenant>:<key> API keys")
+	fs.StringVar(&cfg.Auth.JWTSecret, "jwt-secret", cfg.Auth.JWTSecret, "Secret HS256 JWTs are verified with")
+	fs.StringVar(&cfg.Auth.JWTPublicKey, "jwt-public-key", cfg.Auth.JWTPublicKey, "PEM file of the public key RS256 JWTs are verified with")
+	fs.StringVar(&cfg.Auth.JWTIssuer, "jwt-issuer", cfg.Auth.JWTIssuer, "Issuer JWTs must carry (default: any)")
+	fs.StringVar(&cfg.Auth.JWTAudience, "jwt-audience", cfg.Auth.JWTAudience, "Audience JWTs must carry (default: any)")
+	fs.StringVar(&cfg.Auth.TenantClaim, "jwt-tenant-claim", cfg.Auth.TenantClaim, "JWT claim holding the caller's tenant")
+	fs.IntVar(&cfg.Quota.MaxActiveJobs, "quota-jobs", cfg.Quota.MaxActiveJobs, "Queued or running jobs per tenant (0: unlimited)")
+	fs.Int64Var(&cfg.Quota.MaxStorageMB, "quota-storage-mb", cfg.Quota.MaxStorageMB, "Stored output per tenant in megabytes (0: unlimited)")
+	fs.IntVar(&cfg.Quota.MaxMonthlyMinutes, "quota-minutes", cfg.Quota.MaxMonthlyMinutes, "Minutes of video transcoded per tenant and month (0: unlimited)")
+	fs.Float64Var(&cfg.Quota.UploadRate, "upload-rate", cfg.Quota.UploadRate, "Uploads per minute per API key (0: unlimited)")
+	fs.IntVar(&cfg.Quota.UploadBurst, "upload-burst", cfg.Quota.UploadBurst, "Uploads accepted at once before -upload-rate applies")
+	fs.Int64Var(&cfg.MaxUploadMB, "max-upload-mb", cfg.MaxUploadMB, "Maximum accepted upload size in megabytes")
+	fs.Var((*listValue)(&cfg.Ingest.AllowedHosts), "ingest-allowed-hosts", "Comma-separated hosts that POST /jobs may download from")
+	fs.DurationVar(&cfg.Ingest.Timeout, "ingest-timeout", cfg.Ingest.Timeout, "Timeout for downloading a source URL")
+	fs.StringVar(&cfg.PresetsPath, "presets", cfg.PresetsPath, "Path to the JSON encoding ladder presets")
+	fs.StringVar(&cfg.Job.Preset, "preset", cfg.Job.Preset, "cli, watch: encoding preset (default: the catalog default)")
+	fs.StringVar(&cfg.Job.OutputFormat, "format", cfg.Job.OutputFormat, "cli, watch: output format: hls, dash or both")
+	fs.StringVar(&cfg.Job.SegmentFormat, "segment-format", cfg.Job.SegmentFormat, "cli, watch: segment format: ts or fmp4")
+	fs.BoolVar(&cfg.Job.Encrypt, "encrypt", cfg.Job.Encrypt, "cli, watch: encrypt HLS segments with AES-128")
+	fs.StringVar(&cfg.CLI.Input, "input", cfg.CLI.Input, "cli: source video to transcode")
+	fs.StringVar(&cfg.CLI.Output, "output", cfg.CLI.Output, "cli: output directory (default <output-dir>/<input name>)")
+	fs.Var((*listValue)(&cfg.CLI.Subtitles), "subtitles", "cli: comma-separated sidecar subtitle files (.srt, .vtt, .ttml, .dfxp)")
+	fs.StringVar(&cfg.Watch.Dir, "watch-dir", cfg.Watch.Dir, "watch: drop directory to ingest videos from")
+	fs.DurationVar(&cfg.Watch.PollInterval, "watch-interval", cfg.Watch.PollInterval, "watch: how often the drop directory is scanned")
+	fs.DurationVar(&cfg.Watch.StableFor, "watch-stable-for", cfg.Watch.StableFor, "watch: how long a file must stay unchanged before it is ingested")
+	fs.StringVar(&cfg.Watch.Tenant, "watch-tenant", cfg.Watch.Tenant, "watch: tenant the ingested videos belong to")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// Flags win over every other source, so remember them before the
+	// lower-precedence sources are applied on top of the defaults
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	cfg = Default()
+	if *configPath != "" {
+		if err := applyFile(fs, *configPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("flag -%s: %v", name, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate rejects settings the services cannot start with
+func (c *Config) Validate() error {
+	switch c.Mode {
+	case "api", "worker", "all":
+	case "cli":
+		if c.CLI.Input == "" {
+			return errors.New("mode cli requires -input")
+		}
+	case "watch":
+		switch {
+		case c.Watch.Dir == "":
+			return errors.New("watch-dir must not be empty")
+		case c.Watch.PollInterval <= 0:
+			return errors.New("watch-interval must be positive")
+		case c.Watch.StableFor < 0:
+			return errors.New("watch-stable-for must not be negative")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q (use api, worker, all, cli or watch)", c.Mode)
+	}
+
+	switch c.Queue.Backend {
+	case "kafka":
+		if len(c.Kafka.Brokers) == 0 {
+			return errors.New("at least one Kafka broker is required")
+		}
+	case "memory":
+		// The in-process queue cannot connect an API and a worker in different processes
+		if c.Mode == "api" || c.Mode == "worker" || c.Mode == "watch" {
+			return errors.New("the memory queue requires mode all")
+		}
+	case "file":
+		if c.Queue.Dir == "" {
+			return errors.New("queue-dir must not be empty")
+		}
+	default:
+		return fmt.Errorf("invalid queue %q (use kafka, memory or file)", c.Queue.Backend)
+	}
+
+	switch {
+	case c.HTTP.Addr == "":
+		return errors.New("http-addr must not be empty")
+	case c.Queue.JobsTopic == "" || c.Queue.ProgressTopic == "" || c.Queue.DLQTopic == "":
+		return errors.New("queue topics must not be empty")
+	case c.Queue.JobsTopic == c.Queue.ProgressTopic || c.Queue.JobsTopic == c.Queue.DLQTopic || c.Queue.ProgressTopic == c.Queue.DLQTopic:
+		return errors.New("jobs, progress and dlq topics must differ")
+	case c.Queue.GroupID == "":
+		return errors.New("group-id must not be empty")
+	case c.Queue.MaxAttempts < 1 || c.Queue.MaxAttempts > 10:
+		return errors.New("max-attempts must be between 1 and 10")
+	case c.Queue.RetryBackoff <= 0:
+		return errors.New("retry-backoff must be positive")
+	case c.Queue.MaxJobTime < time.Minute || c.Queue.MaxJobTime > 24*time.Hour:
+		return errors.New("max-job-time must be between 1m and 24h")
+	case c.Storage.UploadsDir == "" || c.Storage.OutputDir == "" || c.Storage.JobsDir == "" || c.Storage.KeysDir == "":
+		return errors.New("storage directories must not be empty")
+	case c.Encryption.KeyURL == "":
+		return errors.New("key-url must not be empty")
+	case c.Encryption.RotateEvery < 0:
+		return errors.New("key-rotation must not be negative")
+	case c.Playback.TTL <= 0:
+		return errors.New("playback-ttl must be positive")
+	case c.Auth.TenantClaim == "":
+		return errors.New("jwt-tenant-claim must not be empty")
+	case c.Quota.MaxActiveJobs < 0 || c.Quota.MaxStorageMB < 0 || c.Quota.MaxMonthlyMinutes < 0:
+		return errors.New("quotas must not be negative")
+	case c.Quota.MaxStorageMB > 1<<23:
+		return errors.New("quota-storage-mb is too large")
+	case c.Quota.UploadRate < 0:
+		return errors.New("upload-rate must not be negative")
+	case c.Quota.UploadRate > 0 && c.Quota.UploadBurst < 1:
+		return errors.New("upload-burst must be at least 1")
+	case c.MaxUploadMB <= 0:
+		return errors.New("max-upload-mb must be positive")
+	case c.MaxUploadMB > 1<<23:
+		return errors.New("max-upload-mb is too large")
+	case c.Ingest.Timeout <= 0:
+		return errors.New("ingest-timeout must be positive")
+	case c.PresetsPath == "":
+		return errors.New("presets must not be empty")
+	}
+	return nil
+}
+
+// applyFile sets flags from a JSON object keyed by flag name. Values may be
+// strings, numbers, booleans or, for list settings, arrays of strings.
+func applyFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	for name, raw := range values {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config %s: unknown setting %q", path, name)
+		}
+		value, err := fileValue(raw)
+		if err != nil {
+			return fmt.Errorf("config %s: %s: %v", path, name, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config %s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+func fileValue(raw any) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case float64:
+		// fmt.Sprint would write large numbers in exponent form, which the
+		// integer flags reject
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.New("list entries must be strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", raw)
+	}
+}
+
+// applyEnv sets every flag whose environment variable is present
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+
+		key := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			if alias, hasAlias := envAliases[f.Name]; hasAlias {
+				key = alias
+				value, ok = os.LookupEnv(alias)
+			}
+		}
+		if !ok {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", key, setErr)
+		}
+	})
+	return err
+}
+
+// listValue is a comma-separated flag; setting it replaces the whole list
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*l = items
+	return nil
+}
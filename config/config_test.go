@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig stores a JSON config file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Default(); !reflect.DeepEqual(*cfg, want) {
+		t.Errorf("expected the defaults\n got %+v\nwant %+v", *cfg, want)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"http-addr": ":9000",
+		"mode": "worker",
+		"max-upload-mb": 1000000,
+		"upload-rate": 0.5,
+		"playback-bind-ip": true,
+		"kafka-brokers": ["file-1:9092", "file-2:9092"],
+		"retry-backoff": "1m"
+	}`)
+	t.Setenv(envPrefix+"MODE", "api")
+	t.Setenv(envPrefix+"RETRY_BACKOFF", "2m")
+	t.Setenv("KAFKA_BROKERS", "env:9092")
+
+	cfg, err := Load("test", []string{"-config", path, "-retry-backoff", "3m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting string
+		got     any
+		want    any
+	}{
+		{"file only", cfg.HTTP.Addr, ":9000"},
+		{"large file number", cfg.MaxUploadMB, int64(1000000)},
+		{"fractional file number", cfg.Quota.UploadRate, 0.5},
+		{"file boolean", cfg.Playback.BindIP, true},
+		{"environment over file", cfg.Mode, "api"},
+		{"environment alias over file", cfg.Kafka.Brokers, []string{"env:9092"}},
+		{"flag over environment and file", cfg.Queue.RetryBackoff, 3 * time.Minute},
+		{"default", cfg.Queue.JobsTopic, "transcoding-jobs"},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFromEnvironment(t *testing.T) {
+	path := writeConfig(t, `{"jobs-topic": "file-jobs"}`)
+	t.Setenv(envPrefix+"CONFIG", path)
+
+	cfg, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Queue.JobsTopic != "file-jobs" {
+		t.Errorf("expected %sCONFIG to load the file, got jobs topic %q", envPrefix, cfg.Queue.JobsTopic)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // Config file content, if any
+		env  map[string]string
+		args []string
+		want string // Part of the error
+	}{
+		{"unknown file setting", `{"no-such-setting": 1}`, nil, nil, "unknown setting"},
+		{"config key in file", `{"config": "other.json"}`, nil, nil, "unknown setting"},
+		{"malformed file", `{"mode": `, nil, nil, "failed to parse"},
+		{"non-string list entry", `{"kafka-brokers": [1]}`, nil, nil, "list entries must be strings"},
+		{"object value", `{"mode": {"a": 1}}`, nil, nil, "unsupported value"},
+		{"fraction for an integer", `{"max-attempts": 2.5}`, nil, nil, "max-attempts"},
+		{"invalid environment value", "", map[string]string{envPrefix + "MAX_ATTEMPTS": "many"}, nil, envPrefix + "MAX_ATTEMPTS"},
+		{"unknown flag", "", nil, []string{"-no-such-flag"}, "no-such-flag"},
+		{"positional argument", "", nil, []string{"extra"}, "unexpected arguments"},
+		{"invalid setting", "", nil, []string{"-mode", "batch"}, "invalid mode"},
+		{"missing file", "", nil, []string{"-config", "/does/not/exist.json"}, "failed to read config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			_, err := Load("test", args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package jobstore
+
+import "time"
+
+type State string
+
+const (
+	StateQueued      State = "queued"
+	StateProbing     State = "probing"
+	StateTranscoding State = "transcoding"
+	StatePackaging   State = "packaging"
+	StateReady       State = "ready"
+	StateFailed      State = "failed"
+)
+
+// Done reports whether the job reached a terminal state
+func (s State) Done() bool {
+	return s == StateReady || s == StateFailed
+}
+
+type Job struct {
+	ID               string    `json:"id"`
+	TenantID         string    `json:"tenant_id,omitempty"`
+	VideoID          string    `json:"video_id"`
+	Title            string    `json:"title"`
+	OriginalFilename string    `json:"original_filename"`
+	State            State     `json:"state"`
+	Error            string    `json:"error,omitempty"`
+	OutputFormat     string    `json:"output_format"`
+	PlaybackURL      string    `json:"playback_url"`
+	DashURL          string    `json:"dash_url,omitempty"`
+	PosterURL        string    `json:"poster_url,omitempty"`
+	ThumbnailURLs    []string  `json:"thumbnail_urls,omitempty"`
+	TrickplayURL     string    `json:"trickplay_url,omitempty"` // WebVTT track of sprite sheet tiles
+	Encrypted        bool      `json:"encrypted,omitempty"`     // Segments need keys from /keys/{videoID}/{keyID}
+	Duration         float64   `json:"duration,omitempty"`      // Source length in seconds, once probed
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
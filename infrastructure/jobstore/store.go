@@ -0,0 +1,180 @@
+package jobstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+type Store interface {
+	Create(job *Job) error
+	Get(id string) (*Job, error)
+	UpdateState(id string, state State, errMsg string) error
+	Update(id string, update func(job *Job)) error
+	List() ([]*Job, error)
+}
+
+// fileStore keeps one JSON document per job so that the API and the worker can
+// share state through a common volume without an external database.
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory %s: %v", dir, err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// Create persists a new job, filling in its ID and timestamps when missing
+func (s *fileStore) Create(job *Job) error {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	if job.State == "" {
+		job.State = StateQueued
+	}
+	now := time.Now().UTC()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(job)
+}
+
+// Get loads a job by its ID
+func (s *fileStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(id)
+}
+
+// UpdateState moves a job to a new state and records the failure reason, if any
+func (s *fileStore) UpdateState(id string, state State, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.read(id)
+	if err != nil {
+		return err
+	}
+
+	job.State = state
+	job.Error = errMsg
+	job.UpdatedAt = time.Now().UTC()
+	return s.write(job)
+}
+
+// Update applies update to a job's metadata and persists the result
+func (s *fileStore) Update(id string, update func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.read(id)
+	if err != nil {
+		return err
+	}
+
+	update(job)
+	job.UpdatedAt = time.Now().UTC()
+	return s.write(job)
+}
+
+// List returns every known job, newest first
+func (s *fileStore) List() ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(matches))
+	for _, path := range matches {
+		job, err := readFile(path)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+func (s *fileStore) path(id string) (string, error) {
+	// Job IDs arrive from URLs, so only accept real UUIDs as file names
+	if _, err := uuid.Parse(id); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *fileStore) read(id string) (*Job, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return readFile(path)
+}
+
+// write replaces the job document atomically so readers in other processes
+// never observe a half-written file
+func (s *fileStore) write(job *Job) error {
+	path, err := s.path(job.ID)
+	if err != nil {
+		return fmt.Errorf("invalid job id %q", job.ID)
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, job.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readFile(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("corrupt job file %s: %v", path, err)
+	}
+	return &job, nil
+}
@@ -1,75 +1,86 @@
+//go:build cgo
+
 package kafka
 
 import (
-	"encoding/json"
-	"go-transcoder/service"
-	"log"
-
-	"log/slog"
+	"context"
+	"errors"
+	"go-transcoder/infrastructure/queue"
+	"strings"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
-type consumerService struct {
-	transcoder service.TranscodeService
+type subscription struct {
+	consumer *kafka.Consumer
+	topic    string
 }
 
-type Consumer interface {
-	RunWorker()
-}
-
-func NewConsumer(transcoder service.TranscodeService) Consumer {
-	return &consumerService{transcoder: transcoder}
-}
+// Subscribe joins the Kafka consumer group. Offsets are committed on Ack;
+// ephemeral groups start at the end of the topic.
+func (q *kafkaQueue) Subscribe(topic, group string, opts queue.SubscribeOptions) (queue.Subscription, error) {
+	offsetReset := "earliest"
+	if opts.Ephemeral {
+		offsetReset = "latest"
+	}
 
-func (c *consumerService) RunWorker() {
-	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers":  "localhost:9092",
-		"group.id":           "transcoder-group",
-		"auto.offset.reset":  "earliest",
+	configMap := kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(q.cfg.Brokers, ","),
+		"group.id":           group,
+		"auto.offset.reset":  offsetReset,
 		"enable.auto.commit": false,
-		
-	})
+	}
+	if opts.MaxProcessing > 0 {
+		configMap["max.poll.interval.ms"] = int(max(opts.MaxProcessing, 5*time.Minute) / time.Millisecond)
+	}
 
+	consumer, err := kafka.NewConsumer(&configMap)
 	if err != nil {
-		log.Fatalf("Failed to create consumer: %s", err)
+		return nil, err
 	}
-	defer consumer.Close()
 
-	if err := consumer.SubscribeTopics([]string{"transcoding-jobs"}, nil); err != nil {
-		log.Fatalf("Failed to subscribe to topics: %s", err)
+	if err := consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		consumer.Close()
+		return nil, err
 	}
+	return &subscription{consumer: consumer, topic: topic}, nil
+}
 
+// Receive polls in short intervals so that ctx cancellation is noticed
+func (s *subscription) Receive(ctx context.Context) (*queue.Delivery, error) {
 	for {
-		msg, err := consumer.ReadMessage(-1)
-		if err != nil {
-			slog.Error("Consumer error", "error", err, "message", msg)
-			continue
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 
-		var job TranscodeJob
-		if err := json.Unmarshal(msg.Value, &job); err != nil {
-			slog.Error("Failed to unmarshal message", "error", err)
+		msg, err := s.consumer.ReadMessage(time.Second)
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
 			continue
 		}
+		if err != nil {
+			return nil, err
+		}
 
-		slog.Info(">>> Processing Job", "VideoName", job.VideoName, "FilePath", job.FilePath)
-		targets := filterResolutions(job.MaxHeight)
-
-		results, err := c.transcoder.StartTranscoding(job.FilePath, job.VideoName, targets, job.Duration)
-		if err == nil {
-			if err := c.transcoder.GenerateMasterPlaylist(job.VideoName, results); err == nil {
-				slog.Info("SUCCESS: Finished", "VideoName", job.VideoName)
-			}
-
-			_, err := consumer.CommitMessage(msg)
-			if err != nil {
-				slog.Error("Failed to commit message", "error", err)
-			}
-
-			slog.Info("Successfully processed job", "VideoName", job.VideoName)
-		} else {
-			slog.Error("Transcoding failed", "VideoName", job.VideoName, "error", err)
+		headers := make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
 		}
+
+		return queue.NewDelivery(s.topic, queue.Message{Key: msg.Key, Value: msg.Value, Headers: headers},
+			func() error {
+				_, err := s.consumer.CommitMessage(msg)
+				return err
+			},
+			// Seeking back makes the next read return this message again
+			func() error {
+				return s.consumer.Seek(msg.TopicPartition, 0)
+			},
+		), nil
 	}
 }
+
+func (s *subscription) Close() error {
+	return s.consumer.Close()
+}
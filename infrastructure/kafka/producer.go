@@ -1,32 +1,38 @@
+//go:build cgo
+
 package kafka
 
 import (
-	"go-transcoder/service"
-	"log"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/queue"
 	"log/slog"
-
+	"strings"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
-type Producer struct {
-	Producer          *kafka.Producer
-	transcoderService service.TranscodeService
+func init() {
+	queue.Register("kafka", func(cfg *config.Config) (queue.Queue, error) {
+		return NewQueue(cfg.Kafka)
+	})
 }
 
-type ProducerInterface interface {
-	Produce(topic string, key []byte, value []byte) error
+// kafkaQueue implements queue.Queue on top of confluent-kafka-go. Consumer
+// groups map onto Kafka consumer groups.
+type kafkaQueue struct {
+	producer *kafka.Producer
+	cfg      config.KafkaConfig
 }
 
-func NewProducer(transcoderService service.TranscodeService) ProducerInterface {
+func NewQueue(cfg config.KafkaConfig) (queue.Queue, error) {
 	confluentProducer, err := kafka.NewProducer(
 		&kafka.ConfigMap{
-			"bootstrap.servers": "localhost:9092",
+			"bootstrap.servers": strings.Join(cfg.Brokers, ","),
 			"security.protocol": "PLAINTEXT",
 		})
 
 	if err != nil {
-		log.Fatalf("Failed to create producer: %s", err)
+		return nil, err
 	}
 
 	go func() {
@@ -39,20 +45,30 @@ func NewProducer(transcoderService service.TranscodeService) ProducerInterface {
 			}
 		}
 	}()
-	return &Producer{
-		Producer:          confluentProducer,
-		transcoderService: transcoderService,
-	}
+	return &kafkaQueue{
+		producer: confluentProducer,
+		cfg:      cfg,
+	}, nil
 }
 
-func (p *Producer) Produce(topic string, key []byte, value []byte) error {
-	deliveryChan := make(chan kafka.Event)
+// Publish produces msg and waits for the broker to acknowledge it
+func (q *kafkaQueue) Publish(topic string, msg queue.Message) error {
+	deliveryChan := make(chan kafka.Event, 1)
+
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
 
-	err := p.Producer.Produce(&kafka.Message{
+	err := q.producer.Produce(&kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Key:            key,
-		Value:          value,
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
 	}, deliveryChan)
+	if err != nil {
+		return err
+	}
 
 	e := <-deliveryChan
 	m := e.(*kafka.Message)
@@ -60,5 +76,11 @@ func (p *Producer) Produce(topic string, key []byte, value []byte) error {
 		slog.Error("Failed to deliver message", "error", m.TopicPartition.Error)
 		return m.TopicPartition.Error
 	}
-	return err
+	return nil
+}
+
+func (q *kafkaQueue) Close() error {
+	q.producer.Flush(5000)
+	q.producer.Close()
+	return nil
 }
@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"go-transcoder/service"
+	"time"
+)
+
+type TranscodeJob struct {
+	JobID         string                   `json:"job_id"`
+	TenantID      string                   `json:"tenant_id,omitempty"` // Owner of the video; its output is written below output/<tenant_id>
+	VideoID       string                   `json:"video_id"`            // Names the output directory
+	FilePath      string                   `json:"file_path"`
+	Title         string                   `json:"title"`
+	Duration      float64                  `json:"duration"`
+	MaxHeight     int                      `json:"max_height"`          // To prevent upscaling!
+	OutputFormat  string                   `json:"output_format"`       // hls, dash or both; empty means hls
+	SegmentFormat string                   `json:"segment_format"`      // ts or fmp4; empty means ts
+	Preset        string                   `json:"preset"`              // Encoding ladder; empty means the default preset
+	Subtitles     []service.SubtitleSource `json:"subtitles,omitempty"` // Sidecar subtitle files in the uploads directory
+	Encrypt       bool                     `json:"encrypt,omitempty"`   // AES-128 encrypt the HLS segments
+}
+
+const (
+	EventTypeState    = "state"
+	EventTypeProgress = "progress"
+)
+
+// ProgressEvent is published by workers on the progress topic and relayed to
+// API clients over Server-Sent Events
+type ProgressEvent struct {
+	Type       string                      `json:"type"`
+	JobID      string                      `json:"job_id"`
+	State      string                      `json:"state,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+	Renditions []service.RenditionProgress `json:"renditions,omitempty"`
+	Timestamp  time.Time                   `json:"timestamp"`
+}
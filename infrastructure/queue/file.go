@@ -0,0 +1,350 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/config"
+	"go-transcoder/service"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func init() {
+	Register("file", func(cfg *config.Config) (Queue, error) {
+		return NewFileQueue(cfg.Queue.Dir)
+	})
+}
+
+const (
+	backlogDir   = ".backlog" // Messages published before any durable group subscribed
+	backlogTTL   = 24 * time.Hour
+	pruneEvery   = time.Minute
+	pendingDir   = "pending"
+	inflightDir  = "inflight"
+	leaseFile    = "lease" // Marks an ephemeral group; its mtime is the group's heartbeat
+	leaseRefresh = 30 * time.Second
+	leaseTTL     = 2 * time.Minute
+	pollInterval = 200 * time.Millisecond
+)
+
+// fileQueue persists every message as a file below dir/<topic>/<group>, so
+// durable groups survive restarts and processes on one host can share a queue.
+// Each message is claimed by renaming it from pending/ to inflight/, which
+// lets consumers of one group compete safely. Unacknowledged messages of a
+// group return to pending/ when it is subscribed again, so a group should be
+// consumed by a single process at a time.
+type fileQueue struct {
+	dir string
+
+	mu         sync.Mutex
+	closed     bool
+	lastPruned map[string]time.Time
+}
+
+func NewFileQueue(dir string) (Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %v", err)
+	}
+	return &fileQueue{dir: dir, lastPruned: make(map[string]time.Time)}, nil
+}
+
+func (q *fileQueue) Publish(topic string, msg Message) error {
+	if q.isClosed() {
+		return ErrClosed
+	}
+	topicDir, err := service.SafeJoin(q.dir, topic)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// Names sort by publish time, which keeps delivery in publish order
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), uuid.New().String())
+
+	if err := os.MkdirAll(topicDir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(topicDir)
+	if err != nil {
+		return err
+	}
+
+	durable := false
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == backlogDir {
+			continue
+		}
+
+		groupDir := filepath.Join(topicDir, entry.Name())
+		ephemeral, alive := groupLease(groupDir)
+		if !alive {
+			os.RemoveAll(groupDir)
+			continue
+		}
+		err := writeMessage(filepath.Join(groupDir, pendingDir), name, data)
+		if errors.Is(err, os.ErrNotExist) {
+			// The group was removed while publishing
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		durable = durable || !ephemeral
+	}
+
+	if !durable {
+		backlog := filepath.Join(topicDir, backlogDir)
+		if err := os.MkdirAll(backlog, 0755); err != nil {
+			return err
+		}
+		q.pruneBacklog(backlog)
+		return writeMessage(backlog, name, data)
+	}
+	return nil
+}
+
+func (q *fileQueue) Subscribe(topic, group string, opts SubscribeOptions) (Subscription, error) {
+	if q.isClosed() {
+		return nil, ErrClosed
+	}
+	groupDir, err := service.SafeJoin(q.dir, topic, group)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(groupDir, 0755); err != nil {
+		return nil, err
+	}
+	// The lease goes first so publishers never mistake the group for a durable one
+	if opts.Ephemeral {
+		if err := touch(filepath.Join(groupDir, leaseFile)); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range []string{pendingDir, inflightDir} {
+		if err := os.MkdirAll(filepath.Join(groupDir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := &fileSubscription{queue: q, topic: topic, groupDir: groupDir, ephemeral: opts.Ephemeral, done: make(chan struct{})}
+	if opts.Ephemeral {
+		go sub.keepLease()
+		return sub, nil
+	}
+
+	// Reclaim deliveries a previous consumer of this group never acknowledged
+	if err := moveAll(filepath.Join(groupDir, inflightDir), filepath.Join(groupDir, pendingDir)); err != nil {
+		return nil, err
+	}
+	if err := moveAll(filepath.Join(filepath.Dir(groupDir), backlogDir), filepath.Join(groupDir, pendingDir)); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (q *fileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	return nil
+}
+
+// pruneBacklog expires old messages of topics that no durable group consumes,
+// such as the progress topic. It scans a backlog at most once per pruneEvery.
+func (q *fileQueue) pruneBacklog(backlog string) {
+	q.mu.Lock()
+	if time.Since(q.lastPruned[backlog]) < pruneEvery {
+		q.mu.Unlock()
+		return
+	}
+	q.lastPruned[backlog] = time.Now()
+	q.mu.Unlock()
+
+	entries, err := os.ReadDir(backlog)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > backlogTTL {
+			os.Remove(filepath.Join(backlog, entry.Name()))
+		}
+	}
+}
+
+func (q *fileQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+type fileSubscription struct {
+	queue     *fileQueue
+	topic     string
+	groupDir  string
+	ephemeral bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (s *fileSubscription) Receive(ctx context.Context) (*Delivery, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.queue.isClosed() {
+			return nil, ErrClosed
+		}
+
+		delivery, err := s.claim()
+		if err != nil || delivery != nil {
+			return delivery, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.done:
+			return nil, ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes the oldest pending message, or returns nil if there is none
+func (s *fileSubscription) claim() (*Delivery, error) {
+	pending := filepath.Join(s.groupDir, pendingDir)
+	inflight := filepath.Join(s.groupDir, inflightDir)
+
+	entries, err := os.ReadDir(pending)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		claimed := filepath.Join(inflight, name)
+
+		// Another consumer of the group may have claimed it first
+		if err := os.Rename(filepath.Join(pending, name), claimed); err != nil {
+			continue
+		}
+
+		var msg Message
+		data, err := os.ReadFile(claimed)
+		if err == nil {
+			err = json.Unmarshal(data, &msg)
+		}
+		if err != nil {
+			slog.Error("Dropping unreadable queue message", "path", claimed, "error", err)
+			os.Remove(claimed)
+			continue
+		}
+
+		return NewDelivery(s.topic, msg,
+			func() error { return os.Remove(claimed) },
+			func() error { return os.Rename(claimed, filepath.Join(pending, name)) },
+		), nil
+	}
+	return nil, nil
+}
+
+// keepLease refreshes an ephemeral group's heartbeat until the subscription closes
+func (s *fileSubscription) keepLease() {
+	ticker := time.NewTicker(leaseRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := touch(filepath.Join(s.groupDir, leaseFile)); err != nil {
+				slog.Error("Failed to refresh queue lease", "group", s.groupDir, "error", err)
+			}
+		}
+	}
+}
+
+func (s *fileSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.ephemeral {
+			os.RemoveAll(s.groupDir)
+		}
+	})
+	return nil
+}
+
+// groupLease reports whether a group is ephemeral and, if so, whether its
+// subscriber is still alive
+func groupLease(groupDir string) (ephemeral bool, alive bool) {
+	info, err := os.Stat(filepath.Join(groupDir, leaseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, true
+	}
+	if err != nil {
+		return true, true
+	}
+	return true, time.Since(info.ModTime()) < leaseTTL
+}
+
+// writeMessage creates dir/name atomically so consumers never see partial files
+func writeMessage(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dir), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// moveAll moves every file in src to dst, tolerating a missing src
+func moveAll(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
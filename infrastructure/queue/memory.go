@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"context"
+	"go-transcoder/config"
+	"sync"
+)
+
+func init() {
+	Register("memory", func(cfg *config.Config) (Queue, error) {
+		return NewMemoryQueue(), nil
+	})
+}
+
+// maxBacklog bounds the messages kept for topics that no durable group
+// consumes, such as the progress topic
+const maxBacklog = 10000
+
+// memoryQueue delivers messages between goroutines of a single process.
+// Nothing survives a restart.
+type memoryQueue struct {
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{} // Closed by Close, waking every blocked Receive
+	topics map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	groups map[string]*memoryGroup
+	// backlog holds messages published before any durable group subscribed
+	backlog []Message
+}
+
+type memoryGroup struct {
+	ephemeral   bool
+	subscribers int
+	pending     []Message
+	notify      chan struct{}
+}
+
+func NewMemoryQueue() Queue {
+	return &memoryQueue{done: make(chan struct{}), topics: make(map[string]*memoryTopic)}
+}
+
+func (q *memoryQueue) Publish(topic string, msg Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	t := q.topic(topic)
+	durable := false
+	for _, group := range t.groups {
+		group.push(msg)
+		durable = durable || !group.ephemeral
+	}
+	if !durable {
+		if len(t.backlog) >= maxBacklog {
+			t.backlog = t.backlog[1:]
+		}
+		t.backlog = append(t.backlog, msg)
+	}
+	return nil
+}
+
+func (q *memoryQueue) Subscribe(topic, group string, opts SubscribeOptions) (Subscription, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrClosed
+	}
+
+	t := q.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memoryGroup{ephemeral: opts.Ephemeral, notify: make(chan struct{}, 1)}
+		t.groups[group] = g
+		if !opts.Ephemeral {
+			for _, msg := range t.backlog {
+				g.push(msg)
+			}
+			t.backlog = nil
+		}
+	}
+	g.subscribers++
+
+	return &memorySubscription{queue: q, topic: topic, name: group, group: g, done: make(chan struct{})}, nil
+}
+
+func (q *memoryQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	return nil
+}
+
+func (q *memoryQueue) topic(name string) *memoryTopic {
+	t, ok := q.topics[name]
+	if !ok {
+		t = &memoryTopic{groups: make(map[string]*memoryGroup)}
+		q.topics[name] = t
+	}
+	return t
+}
+
+// push appends msg and wakes a waiting subscriber; the caller holds the queue lock
+func (g *memoryGroup) push(msg Message) {
+	g.pending = append(g.pending, msg)
+	g.signal()
+}
+
+func (g *memoryGroup) signal() {
+	select {
+	case g.notify <- struct{}{}:
+	default:
+	}
+}
+
+type memorySubscription struct {
+	queue  *memoryQueue
+	topic  string
+	name   string
+	group  *memoryGroup
+	closed bool
+	done   chan struct{} // Closed by Close
+}
+
+func (s *memorySubscription) Receive(ctx context.Context) (*Delivery, error) {
+	for {
+		if msg, ok, err := s.pop(); err != nil {
+			return nil, err
+		} else if ok {
+			return NewDelivery(s.topic, msg, func() error { return nil }, func() error {
+				s.requeue(msg)
+				return nil
+			}), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.group.notify:
+		case <-s.queue.done:
+		case <-s.done:
+		}
+	}
+}
+
+func (s *memorySubscription) pop() (Message, bool, error) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+
+	if s.closed || s.queue.closed {
+		return Message{}, false, ErrClosed
+	}
+	if len(s.group.pending) == 0 {
+		return Message{}, false, nil
+	}
+
+	msg := s.group.pending[0]
+	s.group.pending = s.group.pending[1:]
+	// Other subscribers of the group may be waiting for the remaining messages
+	if len(s.group.pending) > 0 {
+		s.group.signal()
+	}
+	return msg, true, nil
+}
+
+// requeue puts a negatively acknowledged message back at the front of its group
+func (s *memorySubscription) requeue(msg Message) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+
+	s.group.pending = append([]Message{msg}, s.group.pending...)
+	s.group.signal()
+}
+
+func (s *memorySubscription) Close() error {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.group.subscribers--
+	// A wakeup this subscription consumed may have been meant for another one
+	if len(s.group.pending) > 0 {
+		s.group.signal()
+	}
+
+	if s.group.ephemeral && s.group.subscribers == 0 {
+		delete(s.queue.topics[s.topic].groups, s.name)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package queue
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// PublishProgress sends a progress event keyed by job ID. Progress is
+// best-effort, so delivery failures are logged and otherwise ignored.
+func PublishProgress(q Queue, topic string, event ProgressEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal progress event", "error", err)
+		return
+	}
+
+	if err := q.Publish(topic, Message{Key: []byte(event.JobID), Value: value}); err != nil {
+		slog.Error("Failed to publish progress event", "jobID", event.JobID, "error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-transcoder/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by operations on a closed queue or subscription
+var ErrClosed = errors.New("queue closed")
+
+// Message is a keyed payload exchanged over a topic
+type Message struct {
+	Key     []byte            `json:"key,omitempty"`
+	Value   []byte            `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Header returns the value of a header, or "" if it is not set
+func (m Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// Delivery is a received message. It must be either acknowledged once
+// processed or negatively acknowledged to have it delivered again.
+type Delivery struct {
+	Message
+	Topic string
+
+	ack  func() error
+	nack func() error
+}
+
+// NewDelivery is used by backends to hand out a message with its acknowledgement callbacks
+func NewDelivery(topic string, msg Message, ack, nack func() error) *Delivery {
+	return &Delivery{Message: msg, Topic: topic, ack: ack, nack: nack}
+}
+
+// Ack marks the message as processed
+func (d *Delivery) Ack() error {
+	return d.ack()
+}
+
+// Nack returns the message to its group so it is delivered again
+func (d *Delivery) Nack() error {
+	return d.nack()
+}
+
+// SubscribeOptions tune how a group consumes a topic
+type SubscribeOptions struct {
+	// Ephemeral groups only receive messages published while they are
+	// subscribed and are discarded afterwards. Durable groups start from the
+	// oldest retained message and keep their position across restarts.
+	Ephemeral bool
+	// MaxProcessing is how long a delivery may stay unacknowledged before
+	// the backend may consider the consumer dead; zero uses the backend default
+	MaxProcessing time.Duration
+}
+
+// Queue publishes messages to topics and delivers them to consumer groups.
+// Every group receives every message of a topic; consumers of the same group
+// share them.
+type Queue interface {
+	Publish(topic string, msg Message) error
+	Subscribe(topic, group string, opts SubscribeOptions) (Subscription, error)
+	Close() error
+}
+
+// Subscription is one consumer of a group
+type Subscription interface {
+	// Receive blocks until a message is available or ctx is done
+	Receive(ctx context.Context) (*Delivery, error)
+	Close() error
+}
+
+// Factory opens a queue backend from the runtime configuration
+type Factory func(cfg *config.Config) (Queue, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Factory)
+)
+
+// Register makes a backend available to Open. Backends with heavy
+// dependencies, such as Kafka, register themselves when linked in.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, dup := backends[name]; dup {
+		panic("queue: backend registered twice: " + name)
+	}
+	backends[name] = factory
+}
+
+// Open creates the backend selected by cfg.Queue.Backend
+func Open(cfg *config.Config) (Queue, error) {
+	backendsMu.Lock()
+	factory, ok := backends[cfg.Queue.Backend]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("queue backend %q is not available in this build (have %s)", cfg.Queue.Backend, strings.Join(Backends(), ", "))
+	}
+	return factory(cfg)
+}
+
+// Backends lists the registered backend names
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,289 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testBackends opens a fresh queue of every in-process backend
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) Queue
+}{
+	{"memory", func(t *testing.T) Queue { return NewMemoryQueue() }},
+	{"file", func(t *testing.T) Queue {
+		q, err := NewFileQueue(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, q Queue)) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			q := backend.open(t)
+			defer q.Close()
+			test(t, q)
+		})
+	}
+}
+
+func subscribe(t *testing.T, q Queue, topic, group string, opts SubscribeOptions) Subscription {
+	t.Helper()
+	sub, err := q.Subscribe(topic, group, opts)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+	return sub
+}
+
+func publish(t *testing.T, q Queue, topic string, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		if err := q.Publish(topic, Message{Key: []byte("key-" + value), Value: []byte(value), Headers: map[string]string{"x-value": value}}); err != nil {
+			t.Fatalf("publish %s: %v", value, err)
+		}
+	}
+}
+
+// receive waits for the next delivery and checks its value
+func receive(t *testing.T, sub Subscription, want string) *Delivery {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	delivery, err := sub.Receive(ctx)
+	if err != nil {
+		t.Fatalf("receive %s: %v", want, err)
+	}
+	if got := string(delivery.Value); got != want {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+	return delivery
+}
+
+// expectEmpty fails if a delivery arrives within a short wait
+func expectEmpty(t *testing.T, sub Subscription) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*pollInterval)
+	defer cancel()
+
+	if delivery, err := sub.Receive(ctx); err == nil {
+		t.Fatalf("unexpected delivery %q", delivery.Value)
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("receive: %v", err)
+	}
+}
+
+func ack(t *testing.T, delivery *Delivery) {
+	t.Helper()
+	if err := delivery.Ack(); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+}
+
+func TestPublishSubscribeAck(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		publish(t, q, "jobs", "a", "b", "c")
+
+		for _, want := range []string{"a", "b", "c"} {
+			delivery := receive(t, sub, want)
+			if delivery.Topic != "jobs" || string(delivery.Key) != "key-"+want || delivery.Header("x-value") != want {
+				t.Errorf("delivery of %s lost its topic, key or headers: %+v", want, delivery)
+			}
+			ack(t, delivery)
+		}
+		expectEmpty(t, sub)
+	})
+}
+
+func TestNackRedelivers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		publish(t, q, "jobs", "a", "b")
+
+		if err := receive(t, sub, "a").Nack(); err != nil {
+			t.Fatalf("nack: %v", err)
+		}
+		// The rejected message comes back before the rest of the group's messages
+		ack(t, receive(t, sub, "a"))
+		ack(t, receive(t, sub, "b"))
+		expectEmpty(t, sub)
+	})
+}
+
+func TestBacklogBeforeFirstSubscriber(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		publish(t, q, "jobs", "early")
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		ack(t, receive(t, sub, "early"))
+	})
+}
+
+func TestGroupsReceiveEveryMessage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		first := subscribe(t, q, "jobs", "first", SubscribeOptions{})
+		second := subscribe(t, q, "jobs", "second", SubscribeOptions{})
+		publish(t, q, "jobs", "a")
+
+		ack(t, receive(t, first, "a"))
+		ack(t, receive(t, second, "a"))
+	})
+}
+
+func TestConsumersOfAGroupShareMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		one := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		two := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		publish(t, q, "jobs", "a", "b")
+
+		ack(t, receive(t, one, "a"))
+		ack(t, receive(t, two, "b"))
+		expectEmpty(t, one)
+		expectEmpty(t, two)
+	})
+}
+
+func TestEphemeralGroupsOnlySeeNewMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		publish(t, q, "progress", "before")
+		sub := subscribe(t, q, "progress", "api", SubscribeOptions{Ephemeral: true})
+		publish(t, q, "progress", "after")
+
+		ack(t, receive(t, sub, "after"))
+		expectEmpty(t, sub)
+	})
+}
+
+func TestReceiveStopsWithContext(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := sub.Receive(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestClosedQueue(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		q.Close()
+
+		if err := q.Publish("jobs", Message{Value: []byte("late")}); !errors.Is(err, ErrClosed) {
+			t.Errorf("publish after close: expected ErrClosed, got %v", err)
+		}
+		if _, err := sub.Receive(context.Background()); !errors.Is(err, ErrClosed) {
+			t.Errorf("receive after close: expected ErrClosed, got %v", err)
+		}
+	})
+}
+
+func TestCloseWakesBlockedReceivers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		// Several consumers of one group and one of another all wait for messages
+		subs := []Subscription{
+			subscribe(t, q, "jobs", "workers", SubscribeOptions{}),
+			subscribe(t, q, "jobs", "workers", SubscribeOptions{}),
+			subscribe(t, q, "jobs", "workers", SubscribeOptions{}),
+			subscribe(t, q, "progress", "api", SubscribeOptions{Ephemeral: true}),
+		}
+		errs := make(chan error, len(subs))
+		for _, sub := range subs {
+			go func() {
+				_, err := sub.Receive(context.Background())
+				errs <- err
+			}()
+		}
+		time.Sleep(3 * pollInterval)
+		q.Close()
+
+		for range subs {
+			select {
+			case err := <-errs:
+				if !errors.Is(err, ErrClosed) {
+					t.Errorf("expected ErrClosed, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("a blocked Receive did not return after Close")
+			}
+		}
+	})
+}
+
+func TestSubscriptionCloseWakesItsReceive(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, q Queue) {
+		other := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+		errs := make(chan error, 1)
+		go func() {
+			_, err := sub.Receive(context.Background())
+			errs <- err
+		}()
+		time.Sleep(3 * pollInterval)
+		sub.Close()
+
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("expected ErrClosed, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Receive did not return after its subscription was closed")
+		}
+
+		// The group's remaining consumer still gets the messages
+		publish(t, q, "jobs", "a")
+		ack(t, receive(t, other, "a"))
+	})
+}
+
+func TestFileQueueRedeliversAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := subscribe(t, q, "jobs", "workers", SubscribeOptions{})
+	publish(t, q, "jobs", "acked", "unacked", "pending")
+
+	ack(t, receive(t, sub, "acked"))
+	receive(t, sub, "unacked")
+	sub.Close()
+	q.Close()
+
+	// A new process reclaims the delivery its predecessor never acknowledged,
+	// which keeps its place in publish order
+	restarted, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	sub = subscribe(t, restarted, "jobs", "workers", SubscribeOptions{})
+
+	ack(t, receive(t, sub, "unacked"))
+	ack(t, receive(t, sub, "pending"))
+	expectEmpty(t, sub)
+}
+
+func TestMemoryBacklogIsBounded(t *testing.T) {
+	q := NewMemoryQueue()
+	defer q.Close()
+
+	for i := 0; i < maxBacklog+10; i++ {
+		if err := q.Publish("progress", Message{Value: []byte("event")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(q.(*memoryQueue).topics["progress"].backlog); n != maxBacklog {
+		t.Errorf("expected a backlog of %d messages, got %d", maxBacklog, n)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build cgo
+
+package main
+
+// The Kafka queue backend needs cgo for librdkafka, so it is only linked into cgo builds
+import _ "go-transcoder/infrastructure/kafka"
@@ -1,50 +1,99 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"go-transcoder/infrastructure/kafka"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
 	"go-transcoder/server"
 	"go-transcoder/service"
+	"go-transcoder/worker"
 	"log"
 	"log/slog"
-
+	"os"
 )
 
 func main() {
-	// 1. Define flags to choose mode
+	// 1. Load the configuration, choosing the mode among other settings
 	// Usage: go run main.go -mode=api  OR  go run main.go -mode=worker
-	mode := flag.String("mode", "all", "Mode to run the app in: api, worker, or all")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
+
+	presets, err := loadPresets(cfg.PresetsPath)
+	if err != nil {
+		log.Fatalf("Failed to load presets: %s", err)
+	}
+
+	if cfg.Mode == "cli" {
+		os.Exit(runCLI(cfg, presets))
+	}
+
+	services := service.InitService(cfg, presets)
+
+	jobs, err := jobstore.NewFileStore(cfg.Storage.JobsDir)
+	if err != nil {
+		log.Fatalf("Failed to open job store: %s", err)
+	}
 
-	services := service.InitService()
+	q, err := queue.Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s queue: %s", cfg.Queue.Backend, err)
+	}
+	defer q.Close()
 
-	switch *mode {
+	switch cfg.Mode {
 	case "api":
-		runAPI(services)
+		runAPI(cfg, services, jobs, q)
 	case "worker":
-		runWorker(services)
+		runWorker(cfg, services, jobs, q)
 	case "all":
-		slog.Info("Starting in 'all' mode (API + Worker)...")
-		go runWorker(services)
-		runAPI(services)
-	default:
-		log.Fatalf("Invalid mode: %s. Use 'api', 'worker', or 'all'", *mode)
+		slog.Info("Starting in 'all' mode (API + Worker)...", "queue", cfg.Queue.Backend)
+		go runWorker(cfg, services, jobs, q)
+		runAPI(cfg, services, jobs, q)
+	case "watch":
+		runWatch(cfg, services, jobs, q)
 	}
 }
 
-func runAPI(services *service.Service) {
-	kafkaProducer := kafka.NewProducer(services.Transcode)
-	s := server.NewServerService(services.Transcode, kafkaProducer, services.ProgressUI)
+func runAPI(cfg *config.Config, services *service.Service, jobs jobstore.Store, q queue.Queue) {
+	s, err := server.NewServerService(services.Transcode, q, services.ProgressUI, jobs, services.Presets, cfg)
+	if err != nil {
+		log.Fatalf("Failed to start API: %s", err)
+	}
 
 	slog.Info("Initializing API Server...")
 	s.Server()
 }
 
-func runWorker(services *service.Service) {
-	kafkaConsumer := kafka.NewConsumer(services.Transcode)
+func runWorker(cfg *config.Config, services *service.Service, jobs jobstore.Store, q queue.Queue) {
+	w := worker.NewWorker(services.Transcode, services.Presets, q, jobs, cfg)
 
 	slog.Info("Initializing Transcoder Worker...")
-	kafkaConsumer.RunWorker()
+	w.Run()
+}
 
-	select {}
+func runWatch(cfg *config.Config, services *service.Service, jobs jobstore.Store, q queue.Queue) {
+	w, err := server.NewWatcher(services.Transcode, q, services.ProgressUI, jobs, services.Presets, cfg)
+	if err != nil {
+		log.Fatalf("Failed to start watcher: %s", err)
+	}
+
+	slog.Info("Initializing Watch Folder...")
+	w.Run()
+}
+
+// loadPresets reads the preset file, falling back to the built-in ladder when it does not exist
+func loadPresets(path string) (*service.PresetCatalog, error) {
+	presets, err := service.LoadPresets(path)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Preset file not found, using the built-in ladder", "path", path)
+		return service.DefaultPresets(), nil
+	}
+	return presets, err
 }
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go-transcoder/config"
+	"go-transcoder/service"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// principal is the authenticated caller of an API request
+type principal struct {
+	Tenant     string // Empty while authentication is disabled
+	Credential string // Identifies the API key or JWT subject, for rate limits
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller attached to a request by requireAuth
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalKey{}).(principal)
+	return p
+}
+
+// authenticator maps API keys and JWTs to the tenant of their caller
+type authenticator struct {
+	apiKeys     map[[sha256.Size]byte]string // SHA-256 of a key to its tenant
+	jwtSecret   []byte
+	jwtKey      *rsa.PublicKey
+	issuer      string
+	audience    string
+	tenantClaim string
+}
+
+func newAuthenticator(cfg config.AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		apiKeys:     make(map[[sha256.Size]byte]string),
+		issuer:      cfg.JWTIssuer,
+		audience:    cfg.JWTAudience,
+		tenantClaim: cfg.TenantClaim,
+	}
+	if cfg.JWTSecret != "" {
+		a.jwtSecret = []byte(cfg.JWTSecret)
+	}
+
+	for _, entry := range cfg.APIKeys {
+		tenant, key, ok := strings.Cut(entry, ":")
+		if !ok || key == "" {
+			return nil, errors.New("api keys must have the form <tenant>:<key>")
+		}
+		if err := service.ValidateID(tenant); err != nil {
+			return nil, fmt.Errorf("invalid api key tenant: %v", err)
+		}
+		// Keys are looked up by digest, so comparisons do not leak key prefixes
+		a.apiKeys[sha256.Sum256([]byte(key))] = tenant
+	}
+
+	if cfg.JWTPublicKey != "" {
+		key, err := loadRSAPublicKey(cfg.JWTPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		a.jwtKey = key
+	}
+	return a, nil
+}
+
+// loadRSAPublicKey reads a PKIX ("PUBLIC KEY") or PKCS #1 ("RSA PUBLIC KEY") PEM file
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt public key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt public key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("jwt public key is not an RSA key")
+	}
+	return key, nil
+}
+
+func (a *authenticator) enabled() bool {
+	return len(a.apiKeys) > 0 || a.jwtSecret != nil || a.jwtKey != nil
+}
+
+// authenticate identifies the caller from an X-API-Key header or a bearer
+// token, which may be a JWT or an API key
+func (a *authenticator) authenticate(r *http.Request) (principal, error) {
+	credential := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && credential == "" {
+		credential = strings.TrimSpace(bearer)
+		if strings.Count(credential, ".") == 2 {
+			return a.verifyJWT(credential)
+		}
+	}
+	if credential == "" {
+		return principal{}, errors.New("missing credentials")
+	}
+
+	digest := sha256.Sum256([]byte(credential))
+	tenant, ok := a.apiKeys[digest]
+	if !ok {
+		return principal{}, errors.New("unknown api key")
+	}
+	return principal{Tenant: tenant, Credential: "key:" + hex.EncodeToString(digest[:8])}, nil
+}
+
+// requireAuth rejects unauthenticated requests once authentication is
+// configured and attaches the caller to the request context
+func (s *ServerService) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.enabled() {
+			next(w, r)
+			return
+		}
+
+		caller, err := s.auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+	}
+}
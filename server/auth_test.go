@@ -0,0 +1,365 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "jwt-secret"
+
+var (
+	testRSAKeyOnce sync.Once
+	testRSAKey     *rsa.PrivateKey
+)
+
+// rsaTestKey generates one RSA key for all tests, as generation is slow
+func rsaTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	testRSAKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRSAKey = key
+	})
+	return testRSAKey
+}
+
+// writePublicKey stores the PKIX PEM of the test key and returns its path and content
+func writePublicKey(t *testing.T) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&rsaTestKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func jwtPart(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signJWT builds a compact JWT. HS256 tokens are signed with hmacKey, RS256
+// tokens with the test key; any other algorithm gets an empty signature.
+func signJWT(t *testing.T, alg string, hmacKey []byte, claims map[string]any) string {
+	t.Helper()
+	signed := jwtPart(t, map[string]string{"alg": alg, "typ": "JWT"}) + "." + jwtPart(t, claims)
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, rsaTestKey(t), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims returns valid claims for tenant media, changed by the given overrides.
+// A nil override removes the claim.
+func claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"sub":    "user-1",
+		"tenant": "media",
+		"iss":    "https://issuer.example.com",
+		"aud":    "transcoder",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(c, name)
+			continue
+		}
+		c[name] = value
+	}
+	return c
+}
+
+func TestVerifyJWT(t *testing.T) {
+	keyPath, keyPEM := writePublicKey(t)
+	newAuth := func(cfg config.AuthConfig) *authenticator {
+		cfg.TenantClaim = "tenant"
+		cfg.JWTIssuer = "https://issuer.example.com"
+		cfg.JWTAudience = "transcoder"
+		a, err := newAuthenticator(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	hsOnly := newAuth(config.AuthConfig{JWTSecret: testJWTSecret})
+	rsOnly := newAuth(config.AuthConfig{JWTPublicKey: keyPath})
+
+	secret := []byte(testJWTSecret)
+	now := time.Now()
+	valid := signJWT(t, "HS256", secret, claims(nil))
+	header, payload, _ := strings.Cut(valid, ".")
+	payload, _, _ = strings.Cut(payload, ".")
+	forgedPayload := jwtPart(t, claims(map[string]any{"tenant": "sales"}))
+
+	tests := []struct {
+		name   string
+		auth   *authenticator
+		token  string
+		tenant string // Empty when the token must be rejected
+	}{
+		{"HS256", hsOnly, valid, "media"},
+		{"RS256", rsOnly, signJWT(t, "RS256", nil, claims(nil)), "media"},
+		{"alg none", hsOnly, signJWT(t, "none", nil, claims(nil)), ""},
+		{"alg none upper case", hsOnly, signJWT(t, "NONE", nil, claims(nil)), ""},
+		{"alg none with RS256 configured", rsOnly, signJWT(t, "none", nil, claims(nil)), ""},
+		{"HS256 when only RS256 is configured", rsOnly, signJWT(t, "HS256", []byte(testJWTSecret), claims(nil)), ""},
+		{"HS256 signed with the RS256 public key", rsOnly, signJWT(t, "HS256", keyPEM, claims(nil)), ""},
+		{"RS256 when only HS256 is configured", hsOnly, signJWT(t, "RS256", nil, claims(nil)), ""},
+		{"HS512", hsOnly, signJWT(t, "HS512", secret, claims(nil)), ""},
+		{"wrong secret", hsOnly, signJWT(t, "HS256", []byte("other"), claims(nil)), ""},
+		{"tampered claims", hsOnly, header + "." + forgedPayload + "." + strings.SplitN(valid, ".", 3)[2], ""},
+		{"missing signature", hsOnly, header + "." + payload + ".", ""},
+		{"malformed signature", hsOnly, header + "." + payload + ".!!", ""},
+		{"malformed header", hsOnly, "!!." + payload + ".sig", ""},
+		{"expired", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})), ""},
+		{"expired within leeway", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()})), "media"},
+		{"no expiry", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"exp": nil})), ""},
+		{"expiry as string", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"exp": "tomorrow"})), ""},
+		{"not valid yet", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})), ""},
+		{"valid since now", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"nbf": now.Unix()})), "media"},
+		{"wrong issuer", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"iss": "https://evil.example.com"})), ""},
+		{"no issuer", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"iss": nil})), ""},
+		{"wrong audience", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"aud": "billing"})), ""},
+		{"audience list", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"aud": []string{"billing", "transcoder"}})), "media"},
+		{"audience list without ours", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"aud": []string{"billing"}})), ""},
+		{"no tenant", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"tenant": nil})), ""},
+		{"empty tenant", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"tenant": ""})), ""},
+		{"numeric tenant", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"tenant": 42})), ""},
+		{"path traversal tenant", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"tenant": "../sales"})), ""},
+		{"reserved tenant", hsOnly, signJWT(t, "HS256", secret, claims(map[string]any{"tenant": "con"})), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller, err := tt.auth.verifyJWT(tt.token)
+			if tt.tenant == "" {
+				if err == nil {
+					t.Fatalf("expected the token to be rejected, got tenant %q", caller.Tenant)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the token to be accepted, got %v", err)
+			}
+			if caller.Tenant != tt.tenant || caller.Credential != "jwt:media/user-1" {
+				t.Errorf("unexpected caller %+v", caller)
+			}
+		})
+	}
+}
+
+func TestJWTTenantClaim(t *testing.T) {
+	a, err := newAuthenticator(config.AuthConfig{JWTSecret: testJWTSecret, TenantClaim: "org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signJWT(t, "HS256", []byte(testJWTSecret), claims(map[string]any{"org": "sales"}))
+	if caller, err := a.verifyJWT(token); err != nil || caller.Tenant != "sales" {
+		t.Errorf("expected tenant sales from the org claim, got %+v (%v)", caller, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := newAuthenticator(config.AuthConfig{APIKeys: []string{"media:media-key", "sales:sales-key"}, JWTSecret: testJWTSecret, TenantClaim: "tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt := signJWT(t, "HS256", []byte(testJWTSecret), claims(map[string]any{"tenant": "sales"}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		tenant  string // Empty when the request must be rejected
+	}{
+		{"api key header", map[string]string{"X-API-Key": "media-key"}, "media"},
+		{"api key as bearer token", map[string]string{"Authorization": "Bearer sales-key"}, "sales"},
+		{"jwt", map[string]string{"Authorization": "Bearer " + jwt}, "sales"},
+		{"api key header wins over bearer", map[string]string{"X-API-Key": "media-key", "Authorization": "Bearer " + jwt}, "media"},
+		{"unknown api key", map[string]string{"X-API-Key": "guess"}, ""},
+		{"api key prefix", map[string]string{"X-API-Key": "media"}, ""},
+		{"basic auth", map[string]string{"Authorization": "Basic bWVkaWEta2V5Og=="}, ""},
+		{"no credentials", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/list", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			caller, err := a.authenticate(r)
+			if tt.tenant == "" {
+				if err == nil {
+					t.Fatalf("expected the request to be rejected, got %+v", caller)
+				}
+				return
+			}
+			if err != nil || caller.Tenant != tt.tenant {
+				t.Fatalf("expected tenant %s, got %+v (%v)", tt.tenant, caller, err)
+			}
+			if caller.Credential == "" || strings.Contains(caller.Credential, "key-") {
+				t.Errorf("credential %q must identify the caller without revealing the key", caller.Credential)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorRejectsInvalidKeys(t *testing.T) {
+	for _, keys := range [][]string{{"media"}, {"media:"}, {"../media:key"}, {":key"}} {
+		if _, err := newAuthenticator(config.AuthConfig{APIKeys: keys}); err == nil {
+			t.Errorf("expected api keys %q to be rejected", keys)
+		}
+	}
+	if _, err := newAuthenticator(config.AuthConfig{JWTPublicKey: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected a missing public key file to be rejected")
+	}
+}
+
+// tenantServer serves two tenants, each with a finished job whose output exists
+type tenantServer struct {
+	handler http.Handler
+	jobs    map[string]*jobstore.Job // By tenant
+}
+
+func newTenantServer(t *testing.T) *tenantServer {
+	t.Helper()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.APIKeys = []string{"media:media-key", "sales:sales-key"}
+	})
+
+	ts := &tenantServer{handler: s.routes(), jobs: make(map[string]*jobstore.Job)}
+	for _, tenant := range []string{"media", "sales"} {
+		job := &jobstore.Job{TenantID: tenant, VideoID: tenant + "-video", State: jobstore.StateReady, PlaybackURL: "/videos/" + tenant + "/" + tenant + "-video/master.m3u8"}
+		if err := s.jobs.Create(job); err != nil {
+			t.Fatal(err)
+		}
+		ts.jobs[tenant] = job
+		writeOutput(t, s, tenant+"/"+tenant+"-video/master.m3u8", testMaster)
+	}
+	return ts
+}
+
+func (ts *tenantServer) do(method, path, key string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	return serve(ts.handler, r)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ts := newTenantServer(t)
+	mediaJob := ts.jobs["media"]
+
+	// A tus upload started by media
+	w := ts.do(http.MethodPost, "/files", "media-key", map[string]string{"Tus-Resumable": tusVersion, "Upload-Length": "100"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the tus upload to be created, got %d: %s", w.Code, w.Body)
+	}
+	upload := w.Header().Get("Location")
+	patchHeaders := map[string]string{"Tus-Resumable": tusVersion, "Content-Type": tusChunkType, "Upload-Offset": "0"}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		owner   int // Status for media's own key
+		other   int // Status for sales' key
+	}{
+		{"job status", http.MethodGet, "/jobs/" + mediaJob.ID, nil, http.StatusOK, http.StatusNotFound},
+		{"job events", http.MethodGet, "/jobs/" + mediaJob.ID + "/events", nil, http.StatusOK, http.StatusNotFound},
+		{"tus head", http.MethodHead, upload, map[string]string{"Tus-Resumable": tusVersion}, http.StatusOK, http.StatusNotFound},
+		{"tus patch", http.MethodPatch, upload, patchHeaders, http.StatusNoContent, http.StatusNotFound},
+		{"tus delete", http.MethodDelete, upload, map[string]string{"Tus-Resumable": tusVersion}, http.StatusNoContent, http.StatusNotFound},
+		{"video files", http.MethodGet, "/videos/media/media-video/master.m3u8", nil, http.StatusOK, http.StatusForbidden},
+		{"video files of no tenant", http.MethodGet, "/videos/media-video/master.m3u8", nil, http.StatusForbidden, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Refused requests go first, so they cannot have been helped by the owner's
+			if w := ts.do(tt.method, tt.path, "sales-key", tt.headers); w.Code != tt.other {
+				t.Errorf("other tenant: expected status %d, got %d: %s", tt.other, w.Code, w.Body)
+			}
+			if w := ts.do(tt.method, tt.path, "", tt.headers); w.Code != http.StatusUnauthorized {
+				t.Errorf("anonymous: expected status 401, got %d", w.Code)
+			}
+			if w := ts.do(tt.method, tt.path, "media-key", tt.headers); w.Code != tt.owner {
+				t.Errorf("owner: expected status %d, got %d: %s", tt.owner, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestTenantListAndUsage(t *testing.T) {
+	ts := newTenantServer(t)
+
+	for _, tenant := range []string{"media", "sales"} {
+		w := ts.do(http.MethodGet, "/list", tenant+"-key", nil)
+		var videos []VideoSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &videos); err != nil {
+			t.Fatalf("%s: invalid /list response %q: %v", tenant, w.Body, err)
+		}
+		if len(videos) != 1 || videos[0].VideoID != tenant+"-video" {
+			t.Errorf("%s: expected only its own video, got %+v", tenant, videos)
+		}
+
+		w = ts.do(http.MethodGet, "/usage", tenant+"-key", nil)
+		var usage Usage
+		if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+			t.Fatalf("%s: invalid /usage response %q: %v", tenant, w.Body, err)
+		}
+		if usage.Tenant != tenant || usage.StorageBytes != int64(len(testMaster)) {
+			t.Errorf("%s: expected its own usage, got %+v", tenant, usage)
+		}
+	}
+
+	if w := ts.do(http.MethodGet, "/list", "", nil); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected an anonymous /list to be challenged, got %d", w.Code)
+	}
+}
@@ -2,21 +2,108 @@ package server
 
 import (
 	"fmt"
+	"go-transcoder/service"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
-	
 )
 
+// JobRequest carries the client-supplied options shared by every ingest path
+type JobRequest struct {
+	OriginalFilename string
+	Title            string
+	OutputFormat     string
+	SegmentFormat    string
+	Preset           string
+	Subtitles        []service.SubtitleSource // Stored sidecar files
+	Encrypt          bool
+	Tenant           string // Owner of the video, empty while authentication is disabled
+}
+
+// Validate rejects options the worker would not be able to honour
+func (r JobRequest) Validate(presets *service.PresetCatalog) error {
+	if r.Tenant != "" {
+		if err := service.ValidateID(r.Tenant); err != nil {
+			return fmt.Errorf("invalid tenant: %v", err)
+		}
+	}
+	format, err := service.ParseOutputFormat(r.OutputFormat)
+	if err != nil {
+		return err
+	}
+	if r.Encrypt {
+		if err := format.CheckEncryption(); err != nil {
+			return err
+		}
+	}
+	segmentFormat, err := service.ParseSegmentFormat(r.SegmentFormat)
+	if err != nil {
+		return err
+	}
+
+	preset, err := presets.Get(r.Preset)
+	if err != nil {
+		return err
+	}
+	return preset.CheckSegmentFormat(segmentFormat)
+}
+
+// parseEncrypt reads the encrypt option of form fields and tus metadata,
+// where an absent value means no encryption
+func parseEncrypt(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	encrypt, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid encrypt value %q (use true or false)", value)
+	}
+	return encrypt, nil
+}
+
+// maxSubtitleSize bounds sidecar subtitle files, which are read into memory when converted
+const maxSubtitleSize = 10 << 20
+
+// ValidateSubtitle checks the name, size and content type of a sidecar subtitle file
+func (f *FileUpload) ValidateSubtitle(file io.ReadSeeker, filename string, size int64) error {
+	if !service.IsSubtitleFile(filename) {
+		return fmt.Errorf("unsupported subtitle file %q (use .srt, .vtt, .ttml or .dfxp)", filename)
+	}
+	if size > maxSubtitleSize {
+		return fmt.Errorf("subtitle file too large: %d bytes (max %d bytes)", size, maxSubtitleSize)
+	}
+
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	if contentType := http.DetectContentType(buffer[:n]); !strings.HasPrefix(contentType, "text/") {
+		return fmt.Errorf("invalid subtitle file %q: %s", filename, contentType)
+	}
+	return nil
+}
+
 type FileUpload struct {
 	Filename multipart.File
+	MaxSize  int64
 }
 
 func (f *FileUpload) ValidateFile(file multipart.File, header *multipart.FileHeader) error {
-	const maxFileSize = 800 * 1024 * 1024 // 800MB
-	if header.Size > maxFileSize {
-		return fmt.Errorf("file too large: %d bytes (max 800MB)", header.Size)
+	return f.ValidateSource(file, header.Filename, header.Size)
+}
+
+// ValidateSource applies the upload size and type checks to any seekable source
+func (f *FileUpload) ValidateSource(file io.ReadSeeker, filename string, size int64) error {
+	if size > f.MaxSize {
+		return fmt.Errorf("file too large: %d bytes (max %d bytes)", size, f.MaxSize)
 	}
 
 	buffer := make([]byte, 512)
@@ -31,7 +118,7 @@ func (f *FileUpload) ValidateFile(file multipart.File, header *multipart.FileHea
 	contentType := http.DetectContentType(buffer)
 
 	isVid := strings.HasPrefix(contentType, "video/")
-	ext := strings.ToLower(filepath.Ext(header.Filename))
+	ext := strings.ToLower(filepath.Ext(filename))
 	isValidExt := ext == ".mov" || ext == ".mp4" || ext == ".mkv" || ext == ".avi"
 
 	if !isVid && !isValidExt {
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// eventHub fans progress events out to the SSE clients watching each job
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan queue.ProgressEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[string]map[chan queue.ProgressEvent]struct{})}
+}
+
+// subscribe registers a listener for a job and returns a function that removes
+// it. The channel is closed after the job's final state event.
+func (h *eventHub) subscribe(jobID string) (chan queue.ProgressEvent, func()) {
+	ch := make(chan queue.ProgressEvent, 16)
+
+	h.mu.Lock()
+	if h.subscribers[jobID] == nil {
+		h.subscribers[jobID] = make(map[chan queue.ProgressEvent]struct{})
+	}
+	h.subscribers[jobID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subscribers[jobID], ch)
+		if len(h.subscribers[jobID]) == 0 {
+			delete(h.subscribers, jobID)
+		}
+	}
+}
+
+// publish delivers an event without blocking; slow clients simply miss ticks.
+// A job's final state is always delivered, in place of the oldest event a
+// client has not read yet if its buffer is full, and ends its subscriptions.
+func (h *eventHub) publish(event queue.ProgressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	final := event.Type == queue.EventTypeState && jobstore.State(event.State).Done()
+	for ch := range h.subscribers[event.JobID] {
+		if !final {
+			select {
+			case ch <- event:
+			default:
+			}
+			continue
+		}
+
+		// Only publish sends, under h.mu, so one receive frees a slot
+		select {
+		case ch <- event:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- event
+		}
+		close(ch)
+	}
+	if final {
+		delete(h.subscribers, event.JobID)
+	}
+}
+
+func stateEvent(job *jobstore.Job) queue.ProgressEvent {
+	return queue.ProgressEvent{
+		Type:      queue.EventTypeState,
+		JobID:     job.ID,
+		State:     string(job.State),
+		Error:     job.Error,
+		Timestamp: job.UpdatedAt,
+	}
+}
+
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event queue.ProgressEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal event for job %s: %v", event.JobID, err)
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	flusher.Flush()
+}
+
+// relayProgress feeds the hub from the progress topic. Each API instance joins
+// its own ephemeral group so that all instances observe all events.
+func (s *ServerService) relayProgress() {
+	group := "transcoder-api-" + uuid.New().String()
+	sub, err := s.queue.Subscribe(s.cfg.Queue.ProgressTopic, group, queue.SubscribeOptions{Ephemeral: true})
+	if err != nil {
+		log.Fatalf("Failed to subscribe to progress events: %s", err)
+	}
+	defer sub.Close()
+
+	for {
+		delivery, err := sub.Receive(context.Background())
+		if errors.Is(err, queue.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Progress consumer error: %v", err)
+			continue
+		}
+		if err := delivery.Ack(); err != nil {
+			log.Printf("Failed to acknowledge progress event: %v", err)
+		}
+
+		var event queue.ProgressEvent
+		if err := json.Unmarshal(delivery.Value, &event); err != nil {
+			log.Printf("Failed to unmarshal progress event: %v", err)
+			continue
+		}
+		s.events.publish(event)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
+	"go-transcoder/service"
+	"testing"
+)
+
+func progressEvent(jobID string, percent float64) queue.ProgressEvent {
+	return queue.ProgressEvent{Type: queue.EventTypeProgress, JobID: jobID, Renditions: []service.RenditionProgress{{Rendition: "720p", Percent: percent}}}
+}
+
+func finalEvent(jobID string) queue.ProgressEvent {
+	return queue.ProgressEvent{Type: queue.EventTypeState, JobID: jobID, State: string(jobstore.StateReady)}
+}
+
+// drain reads a subscription until it is closed
+func drain(t *testing.T, ch chan queue.ProgressEvent) []queue.ProgressEvent {
+	t.Helper()
+	var events []queue.ProgressEvent
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			t.Fatalf("subscription still open after %d events", len(events))
+		}
+	}
+}
+
+func TestEventHubDeliversFinalStateToSlowSubscribers(t *testing.T) {
+	hub := newEventHub()
+	slow, unsubscribe := hub.subscribe("job")
+	defer unsubscribe()
+	other, unsubscribeOther := hub.subscribe("other")
+	defer unsubscribeOther()
+
+	// Fill the buffer well past its capacity without reading
+	for i := 0; i < 100; i++ {
+		hub.publish(progressEvent("job", float64(i)))
+	}
+	hub.publish(finalEvent("job"))
+
+	events := drain(t, slow)
+	if len(events) != cap(slow) {
+		t.Errorf("expected a full buffer of %d events, got %d", cap(slow), len(events))
+	}
+	if last := events[len(events)-1]; last.Type != queue.EventTypeState || last.State != string(jobstore.StateReady) {
+		t.Errorf("expected the final state last, got %+v", last)
+	}
+
+	// Later events of the finished job reach nobody, other jobs are unaffected
+	hub.publish(progressEvent("job", 100))
+	hub.publish(progressEvent("other", 10))
+	if event := <-other; event.JobID != "other" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if _, ok := hub.subscribers["job"]; ok {
+		t.Error("expected the finished job's subscribers to be removed")
+	}
+}
+
+func TestEventHubDropsProgressForSlowSubscribers(t *testing.T) {
+	hub := newEventHub()
+	ch, unsubscribe := hub.subscribe("job")
+
+	for i := 0; i < 100; i++ {
+		hub.publish(progressEvent("job", float64(i)))
+	}
+	if len(ch) != cap(ch) {
+		t.Errorf("expected %d buffered events, got %d", cap(ch), len(ch))
+	}
+	if event := <-ch; event.Renditions[0].Percent != 0 {
+		t.Errorf("expected the earliest events to be kept, got %v%%", event.Renditions[0].Percent)
+	}
+
+	unsubscribe()
+	if len(hub.subscribers) != 0 {
+		t.Errorf("expected no subscribers after unsubscribing, got %v", hub.subscribers)
+	}
+	// Unsubscribing after the final state closed the channel is harmless
+	ch, unsubscribe = hub.subscribe("job")
+	hub.publish(finalEvent("job"))
+	unsubscribe()
+	drain(t, ch)
+}
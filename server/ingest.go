@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-transcoder/service"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type IngestRequest struct {
+	SourceURL     string `json:"source_url"`
+	SHA256        string `json:"sha256,omitempty"`
+	Title         string `json:"title,omitempty"`
+	OutputFormat  string `json:"output_format,omitempty"`
+	SegmentFormat string `json:"segment_format,omitempty"`
+	Preset        string `json:"preset,omitempty"`
+	Encrypt       bool   `json:"encrypt,omitempty"`
+}
+
+// ingestError carries the HTTP status that best describes why a download was refused
+type ingestError struct {
+	status  int
+	message string
+}
+
+func (e *ingestError) Error() string {
+	return e.message
+}
+
+// urlIngester downloads source videos from allowlisted HTTP servers into the
+// uploads directory so they can follow the regular upload path
+type urlIngester struct {
+	client       *http.Client
+	allowedHosts map[string]struct{}
+	uploadsDir   string
+	validator    FileUpload
+}
+
+func newURLIngester(uploadsDir string, maxSize int64, allowedHosts []string, timeout time.Duration) *urlIngester {
+	i := &urlIngester{
+		allowedHosts: make(map[string]struct{}),
+		uploadsDir:   uploadsDir,
+		validator:    FileUpload{MaxSize: maxSize},
+	}
+	for _, host := range allowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			i.allowedHosts[host] = struct{}{}
+		}
+	}
+
+	i.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !i.allowed(req.URL) {
+				return fmt.Errorf("redirect to host %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+	return i
+}
+
+// fetch downloads rawURL, verifying the optional SHA-256 checksum, and returns
+// the stored file path together with the source file name
+func (i *urlIngester) fetch(ctx context.Context, rawURL, checksum string) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", &ingestError{http.StatusBadRequest, "source_url must be an absolute http(s) URL"}
+	}
+	if !i.allowed(u) {
+		return "", "", &ingestError{http.StatusForbidden, fmt.Sprintf("host %s is not in the ingest allowlist", u.Host)}
+	}
+
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	if checksum != "" {
+		if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+			return "", "", &ingestError{http.StatusBadRequest, "sha256 must be a hex-encoded SHA-256 digest"}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", &ingestError{http.StatusBadRequest, err.Error()}
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return "", "", &ingestError{http.StatusBadGateway, fmt.Sprintf("failed to download source: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", &ingestError{http.StatusBadGateway, fmt.Sprintf("source responded with %s", resp.Status)}
+	}
+	if resp.ContentLength > i.validator.MaxSize {
+		return "", "", &ingestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("source is too large: %d bytes (max %d bytes)", resp.ContentLength, i.validator.MaxSize)}
+	}
+
+	filename := service.SanitizeFilename(path.Base(u.Path))
+
+	if err := os.MkdirAll(i.uploadsDir, 0755); err != nil {
+		return "", "", err
+	}
+	filePath := filepath.Join(i.uploadsDir, uuid.New().String()+service.SafeExt(filename))
+
+	size, sum, err := i.save(filePath, resp.Body)
+	if err != nil {
+		os.Remove(filePath)
+		return "", "", err
+	}
+
+	if checksum != "" && sum != checksum {
+		os.Remove(filePath)
+		return "", "", &ingestError{http.StatusUnprocessableEntity, fmt.Sprintf("checksum mismatch: expected %s, got %s", checksum, sum)}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		os.Remove(filePath)
+		return "", "", err
+	}
+	err = i.validator.ValidateSource(f, filename, size)
+	f.Close()
+	if err != nil {
+		os.Remove(filePath)
+		return "", "", &ingestError{http.StatusBadRequest, err.Error()}
+	}
+
+	return filePath, filename, nil
+}
+
+// save streams the body to disk, enforcing the size limit and hashing as it goes
+func (i *urlIngester) save(filePath string, body io.Reader) (int64, string, error) {
+	out, err := os.Create(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(body, i.validator.MaxSize+1))
+	if err != nil {
+		log.Printf("Failed to download source to %s: %v", filePath, err)
+		return 0, "", &ingestError{http.StatusBadGateway, fmt.Sprintf("failed to download source: %v", err)}
+	}
+	if written > i.validator.MaxSize {
+		return 0, "", &ingestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("source exceeds maximum size of %d bytes", i.validator.MaxSize)}
+	}
+
+	return written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// allowed matches either the exact host:port or the bare host name
+func (i *urlIngester) allowed(u *url.URL) bool {
+	if _, ok := i.allowedHosts[strings.ToLower(u.Host)]; ok {
+		return true
+	}
+	_, ok := i.allowedHosts[strings.ToLower(u.Hostname())]
+	return ok
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mp4Body starts like an MP4 file, so it passes the source type check
+var mp4Body = append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), make([]byte, 1024)...)
+
+func newTestIngester(t *testing.T, maxSize int64, timeout time.Duration, hosts ...string) *urlIngester {
+	t.Helper()
+	return newURLIngester(t.TempDir(), maxSize, hosts, timeout)
+}
+
+func serveBody(body []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+}
+
+// hostOf returns the host:port and bare host name of a test server
+func hostOf(t *testing.T, server *httptest.Server) (string, string) {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host, u.Hostname()
+}
+
+func checksumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// expectIngestError fails unless err is an ingestError with the given status
+func expectIngestError(t *testing.T, err error, status int) {
+	t.Helper()
+	var ingestErr *ingestError
+	if !errors.As(err, &ingestErr) {
+		t.Fatalf("expected ingest error with status %d, got %v", status, err)
+	}
+	if ingestErr.status != status {
+		t.Fatalf("expected status %d, got %d (%s)", status, ingestErr.status, ingestErr.message)
+	}
+}
+
+// expectNoUploads fails if a download left a file behind
+func expectNoUploads(t *testing.T, ingester *urlIngester) {
+	t.Helper()
+	entries, err := os.ReadDir(ingester.uploadsDir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Fatalf("expected no stored uploads, found %d", len(entries))
+	}
+}
+
+func TestIngestAllowlist(t *testing.T) {
+	server := serveBody(mp4Body)
+	defer server.Close()
+	hostPort, hostname := hostOf(t, server)
+
+	tests := []struct {
+		name    string
+		allowed []string
+		status  int // 0 when the download must succeed
+	}{
+		{"bare host", []string{hostname}, 0},
+		{"host and port", []string{hostPort}, 0},
+		{"host names are case insensitive", []string{strings.ToUpper(hostPort)}, 0},
+		{"other port", []string{hostname + ":1"}, http.StatusForbidden},
+		{"other host", []string{"files.internal"}, http.StatusForbidden},
+		{"empty allowlist", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingester := newTestIngester(t, 1<<20, 5*time.Second, tt.allowed...)
+			filePath, filename, err := ingester.fetch(context.Background(), server.URL+"/raw/clip.mp4", "")
+			if tt.status != 0 {
+				expectIngestError(t, err, tt.status)
+				expectNoUploads(t, ingester)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("fetch failed: %v", err)
+			}
+			if filename != "clip.mp4" {
+				t.Errorf("expected filename clip.mp4, got %q", filename)
+			}
+			data, err := os.ReadFile(filePath)
+			if err != nil || string(data) != string(mp4Body) {
+				t.Errorf("stored file does not match the source: %v", err)
+			}
+		})
+	}
+}
+
+func TestIngestRejectsInvalidURLs(t *testing.T) {
+	ingester := newTestIngester(t, 1<<20, 5*time.Second, "files.internal")
+	for _, rawURL := range []string{"", "files.internal/clip.mp4", "ftp://files.internal/clip.mp4", "file:///etc/passwd", "http:///clip.mp4"} {
+		_, _, err := ingester.fetch(context.Background(), rawURL, "")
+		expectIngestError(t, err, http.StatusBadRequest)
+	}
+}
+
+func TestIngestRedirectToDisallowedHost(t *testing.T) {
+	var targetHit bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targetHit = true
+		w.Write(mp4Body)
+	}))
+	defer target.Close()
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/clip.mp4", http.StatusFound)
+	}))
+	defer source.Close()
+
+	// Both servers listen on the same address, so only the source's port is allowed
+	sourceHost, _ := hostOf(t, source)
+	ingester := newTestIngester(t, 1<<20, 5*time.Second, sourceHost)
+
+	_, _, err := ingester.fetch(context.Background(), source.URL+"/clip.mp4", "")
+	expectIngestError(t, err, http.StatusBadGateway)
+	if targetHit {
+		t.Error("redirect target was requested")
+	}
+	expectNoUploads(t, ingester)
+}
+
+func TestIngestRedirectToAllowedHost(t *testing.T) {
+	target := serveBody(mp4Body)
+	defer target.Close()
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/clip.mp4", http.StatusFound)
+	}))
+	defer source.Close()
+
+	sourceHost, _ := hostOf(t, source)
+	targetHost, _ := hostOf(t, target)
+	ingester := newTestIngester(t, 1<<20, 5*time.Second, sourceHost, targetHost)
+
+	if _, _, err := ingester.fetch(context.Background(), source.URL+"/clip.mp4", ""); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+}
+
+func TestIngestSizeLimit(t *testing.T) {
+	const maxSize = 4096
+	body := append(append([]byte{}, mp4Body...), make([]byte, maxSize)...)
+
+	t.Run("declared length", func(t *testing.T) {
+		server := serveBody(body)
+		defer server.Close()
+		hostPort, _ := hostOf(t, server)
+		ingester := newTestIngester(t, maxSize, 5*time.Second, hostPort)
+
+		_, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", "")
+		expectIngestError(t, err, http.StatusRequestEntityTooLarge)
+		expectNoUploads(t, ingester)
+	})
+
+	t.Run("chunked body", func(t *testing.T) {
+		// Flushing before the body is complete sends it chunked, without a
+		// Content-Length, so only the limited reader can catch it
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body[:512])
+			w.(http.Flusher).Flush()
+			w.Write(body[512:])
+		}))
+		defer server.Close()
+		hostPort, _ := hostOf(t, server)
+		ingester := newTestIngester(t, maxSize, 5*time.Second, hostPort)
+
+		_, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", "")
+		expectIngestError(t, err, http.StatusRequestEntityTooLarge)
+		expectNoUploads(t, ingester)
+	})
+
+	t.Run("exactly at the limit", func(t *testing.T) {
+		server := serveBody(body[:maxSize])
+		defer server.Close()
+		hostPort, _ := hostOf(t, server)
+		ingester := newTestIngester(t, maxSize, 5*time.Second, hostPort)
+
+		if _, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", ""); err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+	})
+}
+
+func TestIngestChecksum(t *testing.T) {
+	server := serveBody(mp4Body)
+	defer server.Close()
+	hostPort, _ := hostOf(t, server)
+
+	tests := []struct {
+		name     string
+		checksum string
+		status   int
+	}{
+		{"matching", checksumOf(mp4Body), 0},
+		{"upper case", strings.ToUpper(checksumOf(mp4Body)), 0},
+		{"mismatch", checksumOf([]byte("other")), http.StatusUnprocessableEntity},
+		{"not hex", strings.Repeat("z", 64), http.StatusBadRequest},
+		{"too short", "abcd", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingester := newTestIngester(t, 1<<20, 5*time.Second, hostPort)
+			_, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", tt.checksum)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("fetch failed: %v", err)
+				}
+				return
+			}
+			expectIngestError(t, err, tt.status)
+			expectNoUploads(t, ingester)
+		})
+	}
+}
+
+func TestIngestUpstreamError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	hostPort, _ := hostOf(t, server)
+	ingester := newTestIngester(t, 1<<20, 5*time.Second, hostPort)
+
+	_, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", "")
+	expectIngestError(t, err, http.StatusBadGateway)
+}
+
+func TestIngestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	hostPort, _ := hostOf(t, server)
+	ingester := newTestIngester(t, 1<<20, 100*time.Millisecond, hostPort)
+
+	start := time.Now()
+	_, _, err := ingester.fetch(context.Background(), server.URL+"/clip.mp4", "")
+	expectIngestError(t, err, http.StatusBadGateway)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("download was not cut off by the client timeout, took %s", elapsed)
+	}
+	expectNoUploads(t, ingester)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/service"
+	"strings"
+	"time"
+)
+
+// jwtLeeway tolerates clock skew between the token issuer and the API
+const jwtLeeway = 30 * time.Second
+
+// verifyJWT checks the signature and registered claims of a compact JWT and
+// returns its caller. Only the algorithm of a configured key is accepted, so
+// an RS256 public key can never be used as an HS256 secret.
+func (a *authenticator) verifyJWT(token string) (principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return principal{}, errors.New("malformed jwt")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return principal{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return principal{}, errors.New("malformed jwt signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if a.jwtSecret == nil {
+			return principal{}, errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, a.jwtSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return principal{}, errors.New("invalid jwt signature")
+		}
+	case "RS256":
+		if a.jwtKey == nil {
+			return principal{}, errors.New("RS256 tokens are not accepted")
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(a.jwtKey, crypto.SHA256, digest[:], signature); err != nil {
+			return principal{}, errors.New("invalid jwt signature")
+		}
+	default:
+		return principal{}, fmt.Errorf("unsupported jwt algorithm %q", header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return principal{}, err
+	}
+	if err := a.checkClaims(claims, time.Now()); err != nil {
+		return principal{}, err
+	}
+
+	tenant, _ := claims[a.tenantClaim].(string)
+	if err := service.ValidateID(tenant); err != nil {
+		return principal{}, fmt.Errorf("invalid %s claim: %v", a.tenantClaim, err)
+	}
+	subject, _ := claims["sub"].(string)
+	return principal{Tenant: tenant, Credential: "jwt:" + tenant + "/" + subject}, nil
+}
+
+// checkClaims validates exp, which is required, nbf, iss and aud
+func (a *authenticator) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("jwt has no expiry")
+	}
+	if now.Add(-jwtLeeway).After(time.Unix(int64(exp), 0)) {
+		return errors.New("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt not valid yet")
+	}
+
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return errors.New("unexpected jwt issuer")
+	}
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return errors.New("unexpected jwt audience")
+	}
+	return nil
+}
+
+// hasAudience matches the aud claim, a string or an array of strings
+func hasAudience(claim any, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed jwt")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed jwt")
+	}
+	return nil
+}
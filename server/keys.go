@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"go-transcoder/service"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyTokenCookie carries a playback token for players that cannot add
+// headers to key requests, such as Safari's native HLS playback
+const keyTokenCookie = "playback_token"
+
+// SignKeyToken issues a playback token that releases the keys of a video
+// until expires. Tokens have the form "<unix expiry>.<signature>", where the
+// signature is the unpadded base64url HMAC-SHA256 of "<videoID>\n<unix expiry>".
+func SignKeyToken(secret, videoID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + keyTokenSignature(secret, videoID, expiry)
+}
+
+func keyTokenSignature(secret, videoID, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(videoID + "\n" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyKeyToken checks that a token was signed for videoID and has not expired
+func verifyKeyToken(secret, videoID, token string, now time.Time) error {
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("malformed token")
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(keyTokenSignature(secret, videoID, expiry))) {
+		return errors.New("invalid signature")
+	}
+	if now.Unix() > expires {
+		return errors.New("token expired")
+	}
+	return nil
+}
+
+// requestToken returns the playback token of a request, taken from a bearer
+// Authorization header, the token query parameter or the playback cookie
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(keyTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// keyAuthorized accepts a key token for the video, or the token of a signed
+// playback URL for it, which rewritten playlists add to key URIs. Key URIs
+// carry no tenant, so the playback token's scope must name the video itself.
+func (s *ServerService) keyAuthorized(r *http.Request, videoID, token string) bool {
+	now := time.Now()
+	if secret := s.cfg.Encryption.TokenSecret; secret != "" && verifyKeyToken(secret, videoID, token, now) == nil {
+		return true
+	}
+	if secret := s.cfg.Playback.Secret; secret != "" {
+		parsed, err := parsePlaybackToken(secret, token)
+		return err == nil && strings.HasSuffix(parsed.Scope, "/"+videoID+"/") && parsed.authorizes(parsed.Scope, clientIP(r), now) == nil
+	}
+	return false
+}
+
+// serveKey releases a content key to requests holding a valid key token, or
+// signed playback URL token, for its video. Without a configured secret no
+// key is ever released.
+func (s *ServerService) serveKey(w http.ResponseWriter, r *http.Request) {
+	videoID, keyID := r.PathValue("videoID"), r.PathValue("keyID")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if s.cfg.Encryption.TokenSecret == "" && s.cfg.Playback.Secret == "" {
+		http.Error(w, "Key service is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	token := requestToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="keys"`)
+		http.Error(w, "Playback token required", http.StatusUnauthorized)
+		return
+	}
+	// The token is checked first, so unauthorized clients cannot tell which keys exist
+	if !s.keyAuthorized(r, videoID, token) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	keyPath, err := service.SafeJoin(s.cfg.Storage.KeysDir, videoID, keyID)
+	if err != nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read key %s/%s: %v", videoID, keyID, err)
+		http.Error(w, "Failed to load key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+	w.Write(key)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"go-transcoder/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyKeyToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	valid := SignKeyToken("secret", "video", now.Add(time.Minute))
+	expiry, signature, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"expires this second", SignKeyToken("secret", "video", now), true},
+		{"expired", SignKeyToken("secret", "video", now.Add(-time.Second)), false},
+		{"other video", SignKeyToken("secret", "other", now.Add(time.Minute)), false},
+		{"other secret", SignKeyToken("other", "video", now.Add(time.Minute)), false},
+		{"extended expiry", "1800000000." + signature, false},
+		{"tampered signature", expiry + "." + strings.ToUpper(signature), false},
+		{"no signature", expiry, false},
+		{"empty signature", expiry + ".", false},
+		{"non-numeric expiry", "soon." + signature, false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyKeyToken("secret", "video", tt.token, now)
+			if tt.ok && err != nil {
+				t.Errorf("expected the token to be accepted, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+// newKeyServer stores key-000 of video "video" and routes the key endpoint
+func newKeyServer(t *testing.T, keySecret, playbackSecret string) (http.Handler, []byte) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.KeysDir = t.TempDir()
+	cfg.Encryption.TokenSecret = keySecret
+	cfg.Playback.Secret = playbackSecret
+
+	key := bytes.Repeat([]byte{0xab}, 16)
+	if err := os.MkdirAll(filepath.Join(cfg.Storage.KeysDir, "video"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Storage.KeysDir, "video", "key-000"), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ServerService{cfg: &cfg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{videoID}/{keyID}", s.serveKey)
+	return mux, key
+}
+
+func TestServeKey(t *testing.T) {
+	handler, key := newKeyServer(t, "key-secret", "playback-secret")
+	expires := time.Now().Add(time.Minute)
+	keyToken := SignKeyToken("key-secret", "video", expires)
+
+	tests := []struct {
+		name   string
+		path   string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"bearer key token", "/keys/video/key-000", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+keyToken) }, http.StatusOK},
+		{"query key token", "/keys/video/key-000?token=" + keyToken, nil, http.StatusOK},
+		{"cookie key token", "/keys/video/key-000", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: keyTokenCookie, Value: keyToken}) }, http.StatusOK},
+		{"playback token of the video", "/keys/video/key-000?token=" + SignPlaybackToken("playback-secret", "/videos/media/video/", "", expires), nil, http.StatusOK},
+		{"playback token of another video", "/keys/video/key-000?token=" + SignPlaybackToken("playback-secret", "/videos/media/other/", "", expires), nil, http.StatusForbidden},
+		{"playback token of a whole tenant", "/keys/video/key-000?token=" + SignPlaybackToken("playback-secret", "/videos/media/", "", expires), nil, http.StatusForbidden},
+		{"playback token bound to another client", "/keys/video/key-000?token=" + SignPlaybackToken("playback-secret", "/videos/media/video/", "203.0.113.9", expires), nil, http.StatusForbidden},
+		{"expired key token", "/keys/video/key-000?token=" + SignKeyToken("key-secret", "video", time.Now().Add(-time.Minute)), nil, http.StatusForbidden},
+		{"key token of another video", "/keys/video/key-000?token=" + SignKeyToken("key-secret", "other", expires), nil, http.StatusForbidden},
+		{"no token", "/keys/video/key-000", nil, http.StatusUnauthorized},
+		{"missing key", "/keys/video/key-001?token=" + keyToken, nil, http.StatusNotFound},
+		{"unsafe key id", "/keys/video/..%2fvideo?token=" + keyToken, nil, http.StatusNotFound},
+		{"missing key, unauthorized", "/keys/video/key-001?token=forged", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Error("key responses must not be cached")
+			}
+			if tt.status == http.StatusOK && !bytes.Equal(w.Body.Bytes(), key) {
+				t.Errorf("expected the key, got %x", w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestServeKeyWithoutSecrets(t *testing.T) {
+	handler, _ := newKeyServer(t, "", "")
+	r := httptest.NewRequest(http.MethodGet, "/keys/video/key-000?token="+SignKeyToken("", "video", time.Now().Add(time.Minute)), nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected keys to be withheld without a secret, got %d", w.Code)
+	}
+}
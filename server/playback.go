@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"go-transcoder/service"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// playbackTokenParam is the query parameter signed playback URLs carry their token in
+const playbackTokenParam = "token"
+
+// playbackToken grants access to the files below Scope until Expires,
+// optionally only to the client at IP
+type playbackToken struct {
+	Expires int64
+	Scope   string
+	IP      string
+}
+
+// SignPlaybackToken issues a token for the /videos/ files below scope. Tokens
+// have the form "<payload>.<signature>": the payload is the base64url encoded
+// "<unix expiry>\n<scope>\n<ip>", with ip empty for unbound tokens, and the
+// signature its base64url HMAC-SHA256, both unpadded.
+func SignPlaybackToken(secret, scope, ip string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(expires.Unix(), 10) + "\n" + scope + "\n" + ip))
+	return payload + "." + playbackSignature(secret, payload)
+}
+
+func playbackSignature(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parsePlaybackToken verifies the signature of a token and decodes it
+func parsePlaybackToken(secret, token string) (playbackToken, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(playbackSignature(secret, payload))) {
+		return playbackToken{}, errors.New("invalid signature")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return playbackToken{}, errors.New("malformed token")
+	}
+	fields := strings.Split(string(raw), "\n")
+	if len(fields) != 3 {
+		return playbackToken{}, errors.New("malformed token")
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return playbackToken{}, errors.New("malformed token")
+	}
+	return playbackToken{Expires: expires, Scope: fields[1], IP: fields[2]}, nil
+}
+
+// authorizes checks that the token is valid for urlPath, requested by the client at ip
+func (t playbackToken) authorizes(urlPath, ip string, now time.Time) error {
+	if now.Unix() > t.Expires {
+		return errors.New("token expired")
+	}
+	if t.IP != "" && t.IP != ip {
+		return errors.New("token is bound to another address")
+	}
+	// The scope is a directory, so "/videos/a/" must not match "/videos/ab/",
+	// even for tokens whose scope lacks the trailing slash
+	scope := t.Scope
+	if !strings.HasSuffix(scope, "/") {
+		scope += "/"
+	}
+	if !strings.HasPrefix(path.Clean(urlPath)+"/", scope) {
+		return errors.New("path is outside the token scope")
+	}
+	return nil
+}
+
+// clientIP returns the address of the peer, ignoring forwarding headers,
+// which clients can set freely
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// signPlaybackURLs adds a playback token for a video's files, issued to the
+// requesting client, to every non-empty URL. URLs stay unchanged while
+// signing is disabled.
+func (s *ServerService) signPlaybackURLs(r *http.Request, tenant, videoID string, urls ...*string) {
+	cfg := s.cfg.Playback
+	if cfg.Secret == "" {
+		return
+	}
+
+	ip := ""
+	if cfg.BindIP {
+		ip = clientIP(r)
+	}
+	token := SignPlaybackToken(cfg.Secret, service.VideoURL(tenant, videoID, ""), ip, time.Now().Add(cfg.TTL))
+	for _, u := range urls {
+		*u = withToken(*u, token, "&")
+	}
+}
+
+// signSummary signs the playback, manifest and image URLs of a video
+func (s *ServerService) signSummary(r *http.Request, video *VideoSummary) {
+	urls := []*string{&video.PlaybackURL, &video.DashURL, &video.PosterURL, &video.TrickplayURL}
+	for i := range video.ThumbnailURLs {
+		urls = append(urls, &video.ThumbnailURLs[i])
+	}
+	s.signPlaybackURLs(r, video.TenantID, video.VideoID, urls...)
+}
+
+// protectVideos guards the files under /videos/. With a playback secret,
+// requests with a signed URL are served, and playlists, DASH manifests and
+// trickplay tracks are rewritten on the fly so the URIs they reference carry
+// the request's token too. With authentication enabled, callers may instead
+// present their credentials to read their own tenant's directory. Without
+// either, /videos/ stays public.
+func (s *ServerService) protectVideos(next http.Handler) http.Handler {
+	secret := s.cfg.Playback.Secret
+	if secret == "" && !s.auth.enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get(playbackTokenParam); token != "" && secret != "" {
+			parsed, err := parsePlaybackToken(secret, token)
+			if err == nil {
+				err = parsed.authorizes(r.URL.Path, clientIP(r), time.Now())
+			}
+			if err != nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// Responses are personal to the token, so shared caches must not keep them
+			w.Header().Set("Cache-Control", "private")
+			if s.serveRewritten(w, r, token) {
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.auth.enabled() {
+			http.Error(w, "Signed URL required", http.StatusUnauthorized)
+			return
+		}
+		caller, err := s.auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(path.Clean(r.URL.Path)+"/", "/videos/"+caller.Tenant+"/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "private")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// manifestTypes are the files serveRewritten propagates tokens into
+var manifestTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".vtt":  "text/vtt; charset=utf-8",
+}
+
+// serveRewritten serves a manifest with the token added to its URIs,
+// reporting false for other files and for files that cannot be read, which
+// are left to the file server
+func (s *ServerService) serveRewritten(w http.ResponseWriter, r *http.Request, token string) bool {
+	urlPath := path.Clean(r.URL.Path)
+	contentType, ok := manifestTypes[path.Ext(urlPath)]
+	if !ok {
+		return false
+	}
+
+	filePath := filepath.Join(s.cfg.Storage.OutputDir, filepath.FromSlash(strings.TrimPrefix(urlPath, "/videos/")))
+	if !service.WithinDir(s.cfg.Storage.OutputDir, filePath) {
+		return false
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(rewriteURIs(path.Ext(urlPath), string(data), token))
+	return true
+}
+
+var (
+	playlistURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+	manifestURLAttribute = regexp.MustCompile(`\b(media|initialization|sourceURL)="([^"]*)"`)
+)
+
+// rewriteURIs adds the token to every URI of an HLS playlist, every segment
+// URL of a DASH manifest and every sprite tile of a trickplay track. Other
+// WebVTT files have no URIs and pass unchanged.
+func rewriteURIs(ext, data, token string) []byte {
+	switch ext {
+	case ".mpd":
+		return []byte(manifestURLAttribute.ReplaceAllStringFunc(data, func(attr string) string {
+			match := manifestURLAttribute.FindStringSubmatch(attr)
+			return match[1] + `="` + withToken(match[2], token, "&amp;") + `"`
+		}))
+	case ".m3u8":
+		lines := strings.Split(data, "\n")
+		for i, line := range lines {
+			switch trimmed := strings.TrimSpace(line); {
+			case trimmed == "":
+			case strings.HasPrefix(trimmed, "#"):
+				lines[i] = playlistURIAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+					uri := strings.TrimSuffix(strings.TrimPrefix(attr, `URI="`), `"`)
+					return `URI="` + withToken(uri, token, "&") + `"`
+				})
+			default:
+				lines[i] = withToken(trimmed, token, "&")
+			}
+		}
+		return []byte(strings.Join(lines, "\n"))
+	default:
+		lines := strings.Split(data, "\n")
+		for i, line := range lines {
+			if strings.Contains(line, "#xywh=") {
+				lines[i] = withToken(strings.TrimSpace(line), token, "&")
+			}
+		}
+		return []byte(strings.Join(lines, "\n"))
+	}
+}
+
+// withToken adds the token to a relative or same-host URI, keeping its
+// fragment last. URIs of other hosts are returned unchanged.
+func withToken(uri, token, separator string) string {
+	if uri == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "data:") {
+		return uri
+	}
+
+	uri, fragment, hasFragment := strings.Cut(uri, "#")
+	if strings.Contains(uri, "?") {
+		uri += separator
+	} else {
+		uri += "?"
+	}
+	uri += playbackTokenParam + "=" + token
+	if hasFragment {
+		uri += "#" + fragment
+	}
+	return uri
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer builds a ServerService over temporary directories. The queue,
+// transcoder and progress UI are left out, so only endpoints that do not
+// create jobs can be exercised.
+func newTestServer(t *testing.T, configure func(cfg *config.Config)) *ServerService {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.UploadsDir = t.TempDir()
+	cfg.Storage.OutputDir = t.TempDir()
+	cfg.Storage.KeysDir = t.TempDir()
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	jobs, err := jobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServerService(nil, nil, nil, jobs, service.DefaultPresets(), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// writeOutput stores a file below the output directory
+func writeOutput(t *testing.T, s *ServerService, rel, content string) {
+	t.Helper()
+	path := filepath.Join(s.cfg.Storage.OutputDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+const (
+	testMaster = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="English",URI="audio_128k/index.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,AUDIO="audio"
+720p/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=100000,URI="720p/iframe_index.m3u8"
+`
+	testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/video/key-000"
+#EXTINF:6.000000,
+seg_000.m4s
+#EXTINF:6.000000,
+seg_001.m4s
+#EXT-X-ENDLIST
+`
+	testManifest = `<?xml version="1.0"?>
+<MPD><Period><AdaptationSet><Representation id="720p">
+<SegmentTemplate initialization="720p/init.mp4" media="720p/seg_$Number%03d$.m4s?v=1"/>
+</Representation></AdaptationSet></Period></MPD>
+`
+	testTrickplay = `WEBVTT
+
+00:00:00.000 --> 00:00:10.000
+sprite_000.jpg#xywh=0,0,160,90
+
+00:00:10.000 --> 00:00:20.000
+https://cdn.example.com/sprite_000.jpg#xywh=160,0,160,90
+`
+)
+
+func newPlaybackServer(t *testing.T, bindIP bool) (*ServerService, http.Handler) {
+	t.Helper()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Playback.Secret = "playback-secret"
+		cfg.Playback.BindIP = bindIP
+	})
+	writeOutput(t, s, "media/video/master.m3u8", testMaster)
+	writeOutput(t, s, "media/video/720p/index.m3u8", testMediaPlaylist)
+	writeOutput(t, s, "media/video/720p/seg_000.m4s", "segment")
+	writeOutput(t, s, "media/video/manifest.mpd", testManifest)
+	writeOutput(t, s, "media/video/trickplay/trickplay.vtt", testTrickplay)
+	writeOutput(t, s, "media/video/subtitles/en.vtt", "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello #xywh\n")
+	writeOutput(t, s, "media/videos2/master.m3u8", testMaster)
+	return s, s.routes()
+}
+
+func TestPlaybackTokens(t *testing.T) {
+	s, handler := newPlaybackServer(t, false)
+	secret := s.cfg.Playback.Secret
+	expires := time.Now().Add(time.Minute)
+	valid := SignPlaybackToken(secret, "/videos/media/video/", "", expires)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"valid", "/videos/media/video/master.m3u8", valid, http.StatusOK},
+		{"valid for a segment", "/videos/media/video/720p/seg_000.m4s", valid, http.StatusOK},
+		{"tenant-wide scope", "/videos/media/video/master.m3u8", SignPlaybackToken(secret, "/videos/media/", "", expires), http.StatusOK},
+		{"missing", "/videos/media/video/master.m3u8", "", http.StatusUnauthorized},
+		{"tampered payload", "/videos/media/video/master.m3u8", "A" + payload[1:] + "." + signature, http.StatusForbidden},
+		{"tampered signature", "/videos/media/video/master.m3u8", payload + "." + strings.ToLower(signature), http.StatusForbidden},
+		{"no signature", "/videos/media/video/master.m3u8", payload, http.StatusForbidden},
+		{"other secret", "/videos/media/video/master.m3u8", SignPlaybackToken("other", "/videos/media/video/", "", expires), http.StatusForbidden},
+		{"expired", "/videos/media/video/master.m3u8", SignPlaybackToken(secret, "/videos/media/video/", "", time.Now().Add(-time.Second)), http.StatusForbidden},
+		{"other video", "/videos/media/videos2/master.m3u8", valid, http.StatusForbidden},
+		{"scope is a directory, not a prefix", "/videos/media/videos2/master.m3u8", SignPlaybackToken(secret, "/videos/media/video", "", expires), http.StatusForbidden},
+		{"other tenant", "/videos/sales/video/master.m3u8", valid, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.path
+			if tt.token != "" {
+				target += "?token=" + tt.token
+			}
+			w := serve(handler, httptest.NewRequest(http.MethodGet, target, nil))
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if w.Code == http.StatusOK && !strings.HasPrefix(w.Header().Get("Cache-Control"), "private") {
+				t.Errorf("expected a private response, got Cache-Control %q", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestPlaybackTokenBoundToIP(t *testing.T) {
+	s, handler := newPlaybackServer(t, true)
+	token := SignPlaybackToken(s.cfg.Playback.Secret, "/videos/media/video/", "192.0.2.1", time.Now().Add(time.Minute))
+
+	for _, tt := range []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"192.0.2.1:40000", http.StatusOK},
+		{"192.0.2.2:40000", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/videos/media/video/master.m3u8?token="+token, nil)
+		r.RemoteAddr = tt.remoteAddr
+		// Forwarding headers are ignored, since clients can set them freely
+		r.Header.Set("X-Forwarded-For", "192.0.2.1")
+		if w := serve(handler, r); w.Code != tt.status {
+			t.Errorf("client %s: expected status %d, got %d", tt.remoteAddr, tt.status, w.Code)
+		}
+	}
+
+	// Signed URLs are bound to the client that requested them
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	url := "/videos/media/video/master.m3u8"
+	s.signPlaybackURLs(r, "media", "video", &url)
+	parsed, err := parsePlaybackToken(s.cfg.Playback.Secret, strings.TrimPrefix(url, "/videos/media/video/master.m3u8?token="))
+	if err != nil || parsed.IP != "198.51.100.7" || parsed.Scope != "/videos/media/video/" {
+		t.Errorf("unexpected signed URL %s: %+v (%v)", url, parsed, err)
+	}
+}
+
+func TestPlaybackRewritesManifests(t *testing.T) {
+	s, handler := newPlaybackServer(t, false)
+	token := SignPlaybackToken(s.cfg.Playback.Secret, "/videos/media/video/", "", time.Now().Add(time.Minute))
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := serve(handler, httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		return w
+	}
+
+	tests := []struct {
+		path        string
+		contentType string
+		want        []string
+	}{
+		{"/videos/media/video/master.m3u8", "application/vnd.apple.mpegurl", []string{
+			`URI="audio_128k/index.m3u8?token=` + token + `"`,
+			"\n720p/index.m3u8?token=" + token + "\n",
+			`URI="720p/iframe_index.m3u8?token=` + token + `"`,
+		}},
+		{"/videos/media/video/720p/index.m3u8", "application/vnd.apple.mpegurl", []string{
+			`#EXT-X-MAP:URI="init.mp4?token=` + token + `"`,
+			`#EXT-X-KEY:METHOD=AES-128,URI="/keys/video/key-000?token=` + token + `"`,
+			"\nseg_000.m4s?token=" + token + "\n",
+			"\nseg_001.m4s?token=" + token + "\n",
+			"#EXTINF:6.000000,\n",
+		}},
+		{"/videos/media/video/manifest.mpd", "application/dash+xml", []string{
+			`initialization="720p/init.mp4?token=` + token + `"`,
+			`media="720p/seg_$Number%03d$.m4s?v=1&amp;token=` + token + `"`,
+		}},
+		{"/videos/media/video/trickplay/trickplay.vtt", "text/vtt; charset=utf-8", []string{
+			"sprite_000.jpg?token=" + token + "#xywh=0,0,160,90",
+			"https://cdn.example.com/sprite_000.jpg#xywh=160,0,160,90",
+		}},
+	}
+
+	for _, tt := range tests {
+		w := get(tt.path)
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.path, tt.contentType, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "private, no-store" {
+			t.Errorf("%s: rewritten manifests must not be stored, got Cache-Control %q", tt.path, got)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: expected %q in\n%s", tt.path, want, w.Body)
+			}
+		}
+	}
+
+	// Segments and WebVTT files without sprite tiles are served as stored
+	if body := get("/videos/media/video/720p/seg_000.m4s").Body.String(); body != "segment" {
+		t.Errorf("expected the segment unchanged, got %q", body)
+	}
+	if body := get("/videos/media/video/subtitles/en.vtt").Body.String(); strings.Contains(body, "token=") {
+		t.Errorf("expected subtitles unchanged, got %q", body)
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"seg_000.ts", "seg_000.ts?token=T"},
+		{"seg_000.ts?v=1", "seg_000.ts?v=1&token=T"},
+		{"sprite.jpg#xywh=0,0,1,1", "sprite.jpg?token=T#xywh=0,0,1,1"},
+		{"/keys/video/key-000", "/keys/video/key-000?token=T"},
+		{"https://cdn.example.com/seg.ts", "https://cdn.example.com/seg.ts"},
+		{"data:text/plain,hi", "data:text/plain,hi"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := withToken(tt.uri, "T", "&"); got != tt.want {
+			t.Errorf("withToken(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestVideosArePublicWithoutSecretOrAuth(t *testing.T) {
+	s := newTestServer(t, nil)
+	writeOutput(t, s, "video/master.m3u8", testMaster)
+
+	w := serve(s.routes(), httptest.NewRequest(http.MethodGet, "/videos/video/master.m3u8", nil))
+	if w.Code != http.StatusOK || w.Body.String() != testMaster {
+		t.Errorf("expected the playlist unchanged, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/infrastructure/jobstore"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Usage is a tenant's consumption and limits, as returned by /usage
+type Usage struct {
+	Tenant            string      `json:"tenant,omitempty"`
+	Period            string      `json:"period"` // Calendar month TranscodedMinutes counts, as YYYY-MM in UTC
+	ActiveJobs        int         `json:"active_jobs"`
+	StorageBytes      int64       `json:"storage_bytes"`
+	TranscodedMinutes float64     `json:"transcoded_minutes"`
+	Limits            UsageLimits `json:"limits"`
+}
+
+// UsageLimits are the quotas of a tenant. Omitted limits are unlimited.
+type UsageLimits struct {
+	ActiveJobs        int     `json:"active_jobs,omitempty"`
+	StorageBytes      int64   `json:"storage_bytes,omitempty"`
+	TranscodedMinutes int     `json:"transcoded_minutes,omitempty"`
+	UploadsPerMinute  float64 `json:"uploads_per_minute,omitempty"`
+	UploadBurst       int     `json:"upload_burst,omitempty"`
+}
+
+// quotaError carries the HTTP status of a request refused by a tenant quota
+type quotaError struct {
+	status  int
+	message string
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+// errUnknownDuration refuses sources that cannot be counted against a
+// minutes quota because their duration could not be probed
+var errUnknownDuration = &quotaError{http.StatusUnprocessableEntity, "The source duration could not be determined, which the transcoded minutes quota requires"}
+
+// usage measures a tenant's queued and running jobs, the size of its output
+// directory and the minutes of video it submitted in the current month.
+// Failed jobs do not count against the monthly minutes.
+func (s *ServerService) usage(tenant string, now time.Time) (*Usage, error) {
+	quota := s.cfg.Quota
+	now = now.UTC()
+	usage := &Usage{
+		Tenant: tenant,
+		Period: now.Format("2006-01"),
+		Limits: UsageLimits{
+			ActiveJobs:        quota.MaxActiveJobs,
+			StorageBytes:      quota.MaxStorageMB << 20,
+			TranscodedMinutes: quota.MaxMonthlyMinutes,
+		},
+	}
+	if quota.UploadRate > 0 {
+		usage.Limits.UploadsPerMinute = quota.UploadRate
+		usage.Limits.UploadBurst = quota.UploadBurst
+	}
+
+	jobs, err := s.jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.TenantID != tenant {
+			continue
+		}
+		if !job.State.Done() {
+			usage.ActiveJobs++
+		}
+		created := job.CreatedAt.UTC()
+		if job.State != jobstore.StateFailed && created.Year() == now.Year() && created.Month() == now.Month() {
+			usage.TranscodedMinutes += job.Duration / 60
+		}
+	}
+	usage.TranscodedMinutes = math.Round(usage.TranscodedMinutes*100) / 100
+
+	dir := s.cfg.Storage.OutputDir
+	if tenant != "" {
+		dir = filepath.Join(dir, tenant)
+	}
+	if usage.StorageBytes, err = dirSize(dir); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// exceeded reports the first quota a new job of size bytes and the given
+// minutes of video would break. Callers that do not know the duration yet
+// pass 0, which only refuses tenants without minutes left.
+func (u *Usage) exceeded(size int64, minutes float64) error {
+	limits := u.Limits
+	switch {
+	case limits.ActiveJobs > 0 && u.ActiveJobs >= limits.ActiveJobs:
+		return &quotaError{http.StatusTooManyRequests, fmt.Sprintf("Job quota exceeded: %d of %d jobs are queued or running", u.ActiveJobs, limits.ActiveJobs)}
+	case limits.TranscodedMinutes > 0 && (u.TranscodedMinutes >= float64(limits.TranscodedMinutes) || u.TranscodedMinutes+minutes > float64(limits.TranscodedMinutes)):
+		return &quotaError{http.StatusTooManyRequests, fmt.Sprintf("Monthly quota of %d transcoded minutes exceeded for %s: %.2f used, video needs %.2f", limits.TranscodedMinutes, u.Period, u.TranscodedMinutes, minutes)}
+	case limits.StorageBytes > 0 && u.StorageBytes+size > limits.StorageBytes:
+		return &quotaError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Storage quota exceeded: %d of %d bytes used, upload needs %d more", u.StorageBytes, limits.StorageBytes, size)}
+	}
+	return nil
+}
+
+// dirSize sums the sizes of the regular files below dir, which may not exist yet
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil // Removed while walking
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// admit applies the upload rate limit and the caller's quotas to a request
+// that creates a job of about size bytes, answering the request itself when
+// it is refused. This turns away requests early, before their upload is
+// received; createWithinQuota makes the binding check once the source has
+// been probed. Without authentication, the rate limit applies per client
+// address.
+func (s *ServerService) admit(w http.ResponseWriter, r *http.Request, size int64) bool {
+	caller := principalFrom(r.Context())
+	if s.uploadLimiter != nil {
+		key := caller.Credential
+		if key == "" {
+			key = "ip:" + clientIP(r)
+		}
+		if ok, wait := s.uploadLimiter.allow(key, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Upload rate limit exceeded", http.StatusTooManyRequests)
+			return false
+		}
+	}
+
+	if !s.cfg.Quota.Enabled() {
+		return true
+	}
+	usage, err := s.usage(caller.Tenant, time.Now())
+	if err != nil {
+		log.Printf("Failed to measure usage of tenant %q: %v", caller.Tenant, err)
+		http.Error(w, "Failed to check quota", http.StatusInternalServerError)
+		return false
+	}
+	var quotaErr *quotaError
+	if errors.As(usage.exceeded(size, 0), &quotaErr) {
+		http.Error(w, quotaErr.message, quotaErr.status)
+		return false
+	}
+	return true
+}
+
+// createWithinQuota stores a new job record unless the job, of size bytes
+// and record.Duration seconds, would exceed its tenant's quotas. Checks and
+// record creation are serialized, so concurrent uploads cannot all pass a
+// check made before any of them was recorded.
+func (s *ServerService) createWithinQuota(record *jobstore.Job, size int64) error {
+	if !s.cfg.Quota.Enabled() {
+		return s.jobs.Create(record)
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	usage, err := s.usage(record.TenantID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := usage.exceeded(size, record.Duration/60); err != nil {
+		return err
+	}
+	return s.jobs.Create(record)
+}
+
+// serveUsage reports the caller's consumption against its quotas
+func (s *ServerService) serveUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.usage(principalFrom(r.Context()).Tenant, time.Now())
+	if err != nil {
+		log.Printf("Failed to measure usage: %v", err)
+		http.Error(w, "Failed to measure usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
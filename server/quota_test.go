@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/service"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newQuotaServer(t *testing.T, quota config.QuotaConfig) *ServerService {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Quota = quota
+	cfg.Storage.OutputDir = t.TempDir()
+
+	jobs, err := jobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ServerService{jobs: jobs, cfg: &cfg}
+}
+
+func expectQuotaError(t *testing.T, err error, status int) {
+	t.Helper()
+	var quotaErr *quotaError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("expected quota error with status %d, got %v", status, err)
+	}
+	if quotaErr.status != status {
+		t.Fatalf("expected status %d, got %d (%s)", status, quotaErr.status, quotaErr.message)
+	}
+}
+
+func TestUsageExceeded(t *testing.T) {
+	limits := UsageLimits{ActiveJobs: 3, StorageBytes: 1000, TranscodedMinutes: 10}
+
+	tests := []struct {
+		name    string
+		usage   Usage
+		size    int64
+		minutes float64
+		status  int // 0 when the job fits
+	}{
+		{"within every quota", Usage{ActiveJobs: 2, StorageBytes: 500, TranscodedMinutes: 5}, 100, 4, 0},
+		{"jobs at the limit", Usage{ActiveJobs: 3}, 0, 0, http.StatusTooManyRequests},
+		{"minutes exactly used up by the job", Usage{TranscodedMinutes: 9}, 0, 1, 0},
+		{"long video near the limit", Usage{TranscodedMinutes: 9.9}, 0, 600, http.StatusTooManyRequests},
+		{"no minutes left, duration unknown", Usage{TranscodedMinutes: 10}, 0, 0, http.StatusTooManyRequests},
+		{"some minutes left, duration unknown", Usage{TranscodedMinutes: 9.9}, 0, 0, 0},
+		{"upload fills the storage", Usage{StorageBytes: 900}, 100, 0, 0},
+		{"upload exceeds the storage", Usage{StorageBytes: 900}, 101, 0, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := tt.usage
+			usage.Limits = limits
+			err := usage.exceeded(tt.size, tt.minutes)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("expected the job to fit, got %v", err)
+				}
+				return
+			}
+			expectQuotaError(t, err, tt.status)
+		})
+	}
+}
+
+func TestCreateWithinQuotaCountsTheNewVideo(t *testing.T) {
+	s := newQuotaServer(t, config.QuotaConfig{MaxMonthlyMinutes: 10})
+
+	used := &jobstore.Job{TenantID: "media", VideoID: "used", State: jobstore.StateReady, Duration: 9.9 * 60}
+	if err := s.jobs.Create(used); err != nil {
+		t.Fatal(err)
+	}
+
+	long := &jobstore.Job{TenantID: "media", VideoID: "long", Duration: 10 * 3600}
+	expectQuotaError(t, s.createWithinQuota(long, 0), http.StatusTooManyRequests)
+
+	// Other tenants and failed jobs do not count
+	other := &jobstore.Job{TenantID: "sales", VideoID: "other", Duration: 5 * 60}
+	if err := s.createWithinQuota(other, 0); err != nil {
+		t.Fatalf("expected another tenant's job to fit, got %v", err)
+	}
+	if err := s.jobs.UpdateState(used.ID, jobstore.StateFailed, "failed"); err != nil {
+		t.Fatal(err)
+	}
+	short := &jobstore.Job{TenantID: "media", VideoID: "short", Duration: 5 * 60}
+	if err := s.createWithinQuota(short, 0); err != nil {
+		t.Fatalf("expected the job to fit once the failed job is not counted, got %v", err)
+	}
+}
+
+func TestCreateWithinQuotaIsAtomic(t *testing.T) {
+	const limit = 10
+	s := newQuotaServer(t, config.QuotaConfig{MaxMonthlyMinutes: limit})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*limit)
+	for i := 0; i < 3*limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.createWithinQuota(&jobstore.Job{TenantID: "media", VideoID: "video", Duration: 60}, 0)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		expectQuotaError(t, err, http.StatusTooManyRequests)
+	}
+	if created != limit {
+		t.Errorf("expected %d one-minute jobs to fit a %d minute quota, got %d", limit, limit, created)
+	}
+
+	usage, err := s.usage("media", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.TranscodedMinutes != limit || usage.ActiveJobs != limit {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
+
+// fakeProbe reports a fixed source duration; the other progress UI methods are unused
+type fakeProbe struct {
+	service.ProgressUIService
+	duration float64
+	err      error
+}
+
+func (f fakeProbe) GetDuration(string) (float64, error) {
+	return f.duration, f.err
+}
+
+func TestEnqueueRejectsUnknownDurationUnderMinutesQuota(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe fakeProbe
+	}{
+		{"probe failed", fakeProbe{err: errors.New("ffprobe failed")}},
+		{"no duration", fakeProbe{}},
+		{"invalid duration", fakeProbe{duration: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuotaServer(t, config.QuotaConfig{MaxMonthlyMinutes: 10})
+			s.uiService = tt.probe
+			source := filepath.Join(t.TempDir(), "clip.mp4")
+			if err := os.WriteFile(source, mp4Body, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := s.enqueueJob(source, JobRequest{OriginalFilename: "clip.mp4", Tenant: "media"})
+			if !errors.Is(err, errUnknownDuration) {
+				t.Fatalf("expected errUnknownDuration, got %v", err)
+			}
+			expectQuotaError(t, err, http.StatusUnprocessableEntity)
+			if jobs, _ := s.jobs.List(); len(jobs) != 0 {
+				t.Errorf("expected no job to be created, got %d", len(jobs))
+			}
+		})
+	}
+}
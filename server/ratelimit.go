@@ -0,0 +1,73 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many buckets rateLimiter keeps before it forgets the
+// ones that refilled completely
+const maxIdleBuckets = 1024
+
+// rateLimiter is a token bucket per caller: each caller may spend burst
+// tokens at once, refilled at rate tokens per second. Buckets live in
+// memory, so every API process enforces the limit on its own.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the caller's bucket or reports how long the
+// caller has to wait for the next one
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		l.prune(now)
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+}
+
+// prune drops full buckets, which behave exactly like new ones
+func (l *rateLimiter) prune(now time.Time) {
+	if len(l.buckets) < maxIdleBuckets {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -2,60 +2,168 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"go-transcoder/infrastructure/kafka"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
 	"go-transcoder/service"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Response structure for JSON communication
 type UploadResponse struct {
 	Message     string `json:"message"`
-	VideoName   string `json:"video_name"`
+	JobID       string `json:"job_id"`
+	VideoID     string `json:"video_id"`
+	Title       string `json:"title"`
 	PlaybackURL string `json:"playback_url"`
+	DashURL     string `json:"dash_url,omitempty"`
+	StatusURL   string `json:"status_url"`
+}
+
+// VideoSummary is the public metadata of a video as returned by /list
+type VideoSummary struct {
+	TenantID         string         `json:"tenant_id,omitempty"`
+	VideoID          string         `json:"video_id"`
+	Title            string         `json:"title"`
+	OriginalFilename string         `json:"original_filename"`
+	State            jobstore.State `json:"state"`
+	JobID            string         `json:"job_id"`
+	OutputFormat     string         `json:"output_format"`
+	PlaybackURL      string         `json:"playback_url"`
+	DashURL          string         `json:"dash_url,omitempty"`
+	PosterURL        string         `json:"poster_url,omitempty"`
+	ThumbnailURLs    []string       `json:"thumbnail_urls,omitempty"`
+	TrickplayURL     string         `json:"trickplay_url,omitempty"`
+	Encrypted        bool           `json:"encrypted,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+func newVideoSummary(job *jobstore.Job) VideoSummary {
+	return VideoSummary{
+		TenantID:         job.TenantID,
+		VideoID:          job.VideoID,
+		Title:            job.Title,
+		OriginalFilename: job.OriginalFilename,
+		State:            job.State,
+		JobID:            job.ID,
+		OutputFormat:     job.OutputFormat,
+		PlaybackURL:      job.PlaybackURL,
+		DashURL:          job.DashURL,
+		PosterURL:        job.PosterURL,
+		ThumbnailURLs:    job.ThumbnailURLs,
+		TrickplayURL:     job.TrickplayURL,
+		Encrypted:        job.Encrypted,
+		CreatedAt:        job.CreatedAt,
+	}
 }
 
 type ServerService struct {
 	transcoder    service.TranscodeService
-	kafkaProducer kafka.ProducerInterface
+	queue         queue.Queue
 	uiService     service.ProgressUIService
+	jobs          jobstore.Store
+	presets       *service.PresetCatalog
+	events        *eventHub
+	cfg           *config.Config
+	maxUploadSize int64
+	ingester      *urlIngester
+	auth          *authenticator
+	uploadLimiter *rateLimiter // Nil without an upload rate limit
+	quotaMu       sync.Mutex   // Serializes quota checks with the job records they admit
 }
 
+// multipartOverhead leaves room for form boundaries and fields around the file
+const multipartOverhead = 1 << 20
+
 type ServerServiceInterface interface {
 	Server()
 }
 
-func NewServerService(transcoder service.TranscodeService, kafkaProducer kafka.ProducerInterface, uiService service.ProgressUIService) ServerServiceInterface {
+func NewServerService(transcoder service.TranscodeService, q queue.Queue, uiService service.ProgressUIService, jobs jobstore.Store, presets *service.PresetCatalog, cfg *config.Config) (ServerServiceInterface, error) {
+	return newServerService(transcoder, q, uiService, jobs, presets, cfg)
+}
+
+func newServerService(transcoder service.TranscodeService, q queue.Queue, uiService service.ProgressUIService, jobs jobstore.Store, presets *service.PresetCatalog, cfg *config.Config) (*ServerService, error) {
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploadLimiter *rateLimiter
+	if cfg.Quota.UploadRate > 0 {
+		uploadLimiter = newRateLimiter(cfg.Quota.UploadRate, cfg.Quota.UploadBurst)
+	}
+
 	return &ServerService{
 		transcoder:    transcoder,
-		kafkaProducer: kafkaProducer,
+		queue:         q,
 		uiService:     uiService,
-	}
+		jobs:          jobs,
+		presets:       presets,
+		events:        newEventHub(),
+		cfg:           cfg,
+		maxUploadSize: cfg.MaxUploadSize(),
+		ingester:      newURLIngester(cfg.Storage.UploadsDir, cfg.MaxUploadSize(), cfg.Ingest.AllowedHosts, cfg.Ingest.Timeout),
+		auth:          auth,
+		uploadLimiter: uploadLimiter,
+	}, nil
 }
 
 func (s *ServerService) Server() {
+	go s.relayProgress()
+
+	server := &http.Server{
+		Addr:    s.cfg.HTTP.Addr,
+		Handler: s.routes(),
+	}
+
+	if s.cfg.Encryption.TokenSecret == "" && s.cfg.Playback.Secret == "" {
+		log.Printf("Neither -key-secret nor -playback-secret configured: keys of encrypted videos will not be released")
+	}
+	log.Printf("Server is listening on %s", s.cfg.HTTP.Addr)
+	log.Fatal(server.ListenAndServe())
+}
+
+// routes registers every endpoint of the API
+func (s *ServerService) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.Handle("/videos/", http.StripPrefix("/videos/", http.FileServer(http.Dir("output"))))
+	mux.Handle("/videos/", s.protectVideos(http.StripPrefix("/videos/", http.FileServer(http.Dir(s.cfg.Storage.OutputDir)))))
+
+	// Key Endpoint: Releases the AES-128 keys of encrypted videos to holders of a playback token
+	mux.HandleFunc("GET /keys/{videoID}/{keyID}", s.serveKey)
 
 	// 2. Upload Endpoint
-	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
-		var uploadHandler FileUpload
+	mux.HandleFunc("/upload", s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		uploadHandler := FileUpload{MaxSize: s.maxUploadSize}
 
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		if !s.admit(w, r, max(r.ContentLength, 0)) {
+			return
+		}
 
-		// Limit upload size to 800MB
-		if err := r.ParseMultipartForm(800 << 20); err != nil {
-			http.Error(w, "Failed to parse multipart form (Max 800MB)", http.StatusBadRequest)
+		// Reject bodies above the upload limit; larger form parts spill to disk
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize+multipartOverhead)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse multipart form (Max %d bytes)", s.maxUploadSize), http.StatusBadRequest)
 			return
 		}
+		defer r.MultipartForm.RemoveAll()
 
 		file, header, err := r.FormFile("file")
 		if err != nil {
@@ -64,12 +172,40 @@ func (s *ServerService) Server() {
 		}
 		defer file.Close()
 
+		encrypt, err := parseEncrypt(r.FormValue("encrypt"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		jobReq := JobRequest{
+			OriginalFilename: header.Filename,
+			Title:            r.FormValue("title"),
+			OutputFormat:     r.FormValue("format"),
+			SegmentFormat:    r.FormValue("segment_format"),
+			Preset:           r.FormValue("preset"),
+			Encrypt:          encrypt,
+			Tenant:           principalFrom(r.Context()).Tenant,
+		}
+		if err := jobReq.Validate(s.presets); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Validate file type and size
 		if err := uploadHandler.ValidateFile(file, header); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		subtitles := r.MultipartForm.File["subtitles"]
+		for _, subtitle := range subtitles {
+			if err := validateSubtitlePart(&uploadHandler, subtitle); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		// Save the file to the local disk temporarily
 		filePath, err := s.transcoder.StoreFile(file, header)
 		if err != nil {
@@ -77,72 +213,380 @@ func (s *ServerService) Server() {
 			return
 		}
 
-		// Prepare metadata for response
-		videoName := strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
-		playbackURL := fmt.Sprintf("/videos/%s/master.m3u8", videoName)
+		for _, subtitle := range subtitles {
+			source, err := s.storeSubtitle(subtitle)
+			if err != nil {
+				http.Error(w, "Failed to store subtitles", http.StatusInternalServerError)
+				return
+			}
+			jobReq.Subtitles = append(jobReq.Subtitles, source)
+		}
 
-		go func() {
+		resp, err := s.enqueueJob(filePath, jobReq)
+		if err != nil {
+			os.Remove(filePath)
+			for _, subtitle := range jobReq.Subtitles {
+				os.Remove(subtitle.FilePath)
+			}
+			writeEnqueueError(w, err)
+			return
+		}
+
+		s.signPlaybackURLs(r, jobReq.Tenant, resp.VideoID, &resp.PlaybackURL, &resp.DashURL)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(resp)
+	}))
 
-			duration, _ := s.uiService.GetDuration(filePath)
-			_, originalHeight, _, _ := s.transcoder.GetVariantMetadata(filePath)
+	// Resumable Upload Endpoints (tus 1.0)
+	newTusHandler(s.cfg.Storage.UploadsDir, s.maxUploadSize, s.presets, s.enqueueJob, s.admit).register(mux, s.requireAuth)
 
-			job := kafka.TranscodeJob{
-				FilePath:  filePath,
-				VideoName: videoName,
-				Duration:  duration,
-				MaxHeight: originalHeight,
-				VideoID:   videoName,
-			}
+	// URL Ingest Endpoint: Downloads a source video from an allowlisted host
+	mux.HandleFunc("POST /jobs", s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		var req IngestRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		jobReq := JobRequest{
+			Title:         req.Title,
+			OutputFormat:  req.OutputFormat,
+			SegmentFormat: req.SegmentFormat,
+			Preset:        req.Preset,
+			Encrypt:       req.Encrypt,
+			Tenant:        principalFrom(r.Context()).Tenant,
+		}
+		if err := jobReq.Validate(s.presets); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The source size is unknown until it is downloaded
+		if !s.admit(w, r, 0) {
+			return
+		}
 
-			jobBytes, _ := json.Marshal(job)
-			if err := s.kafkaProducer.Produce("transcoding-jobs", []byte(videoName), jobBytes); err != nil {
-				log.Printf("Failed to produce Kafka message for %s: %v", videoName, err)
-			} else {
-				log.Printf("Enqueued transcoding job for %s", videoName)
+		filePath, filename, err := s.ingester.fetch(r.Context(), req.SourceURL, req.SHA256)
+		if err != nil {
+			var ingestErr *ingestError
+			if errors.As(err, &ingestErr) {
+				http.Error(w, ingestErr.message, ingestErr.status)
+				return
 			}
+			log.Printf("Failed to ingest %s: %v", req.SourceURL, err)
+			http.Error(w, "Failed to ingest source", http.StatusInternalServerError)
+			return
+		}
 
-			log.Printf("Successfully finished transcoding: %s", videoName)
-		}()
+		jobReq.OriginalFilename = filename
+		resp, err := s.enqueueJob(filePath, jobReq)
+		if err != nil {
+			os.Remove(filePath)
+			writeEnqueueError(w, err)
+			return
+		}
 
+		s.signPlaybackURLs(r, jobReq.Tenant, resp.VideoID, &resp.PlaybackURL, &resp.DashURL)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(resp)
+	}))
 
-		resp := UploadResponse{
-			Message:     "Video accepted and processing started.",
-			VideoName:   videoName,
-			PlaybackURL: playbackURL,
+	// Job Status Endpoint: Reports the lifecycle state of a single job
+	mux.HandleFunc("GET /jobs/{id}", s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		job, err := s.jobs.Get(r.PathValue("id"))
+		if errors.Is(err, jobstore.ErrNotFound) || err == nil && !s.ownsJob(r, job) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
 		}
-		json.NewEncoder(w).Encode(resp)
-	})
+		if err != nil {
+			http.Error(w, "Failed to load job", http.StatusInternalServerError)
+			return
+		}
+
+		urls := []*string{&job.PlaybackURL, &job.DashURL, &job.PosterURL, &job.TrickplayURL}
+		for i := range job.ThumbnailURLs {
+			urls = append(urls, &job.ThumbnailURLs[i])
+		}
+		s.signPlaybackURLs(r, job.TenantID, job.VideoID, urls...)
 
-	// 3. List Endpoint: Shows all processed videos
-	mux.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
-		entries, err := os.ReadDir("output")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}))
+
+	// Job Events Endpoint: Streams state transitions and progress over SSE
+	mux.HandleFunc("GET /jobs/{id}/events", s.requireAuth(s.streamJobEvents))
+
+	// 3. List Endpoint: Shows all known videos with their metadata
+	mux.HandleFunc("/list", s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := s.jobs.List()
 		if err != nil {
-			http.Error(w, "Could not read output directory", http.StatusInternalServerError)
+			http.Error(w, "Could not read job store", http.StatusInternalServerError)
 			return
 		}
 
-		var videos []string
-		for _, e := range entries {
-			if e.IsDir() {
-				videos = append(videos, e.Name())
+		videos := make([]VideoSummary, 0, len(jobs))
+		for _, job := range jobs {
+			if !s.ownsJob(r, job) {
+				continue
 			}
+			video := newVideoSummary(job)
+			s.signSummary(r, &video)
+			videos = append(videos, video)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(videos)
-	})
+	}))
+
+	// Usage Endpoint: Reports the caller's consumption against its quotas
+	mux.HandleFunc("GET /usage", s.requireAuth(s.serveUsage))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "index.html")
 	})
+	return mux
+}
 
-	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+// enqueueJob registers a job for a stored upload under a fresh video ID and
+// hands it to the transcoding queue in the background, once the source has
+// been probed. The title defaults to the original file name. Jobs that would
+// exceed the tenant's quotas, or whose duration a minutes quota cannot be
+// checked for, are refused with a *quotaError, leaving the stored file to
+// the caller.
+func (s *ServerService) enqueueJob(filePath string, req JobRequest) (*UploadResponse, error) {
+	format, err := service.ParseOutputFormat(req.OutputFormat)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println("Server is running on http://localhost:8080")
-	log.Fatal(server.ListenAndServe())
+	originalFilename := service.SanitizeFilename(req.OriginalFilename)
+	title := service.SanitizeTitle(req.Title)
+	if title == "" {
+		title = strings.TrimSuffix(originalFilename, filepath.Ext(originalFilename))
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// The duration counts against the tenant's monthly quota, so it is
+	// probed before the job is admitted
+	duration, err := s.uiService.GetDuration(filePath)
+	if err != nil || duration <= 0 {
+		if s.cfg.Quota.MaxMonthlyMinutes > 0 {
+			return nil, errUnknownDuration
+		}
+		duration = 0
+	}
+
+	videoID := uuid.New().String()
+	playbackURL, dashURL := manifestURLs(req.Tenant, videoID, format)
+
+	record := &jobstore.Job{
+		TenantID:         req.Tenant,
+		VideoID:          videoID,
+		Title:            title,
+		OriginalFilename: originalFilename,
+		OutputFormat:     string(format),
+		PlaybackURL:      playbackURL,
+		DashURL:          dashURL,
+		Encrypted:        req.Encrypt,
+		Duration:         duration,
+	}
+	if err := s.createWithinQuota(record, info.Size()); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		s.setState(record.ID, jobstore.StateProbing, nil)
+
+		_, originalHeight, _, _ := s.transcoder.GetVariantMetadata(filePath)
+
+		job := queue.TranscodeJob{
+			JobID:         record.ID,
+			TenantID:      req.Tenant,
+			VideoID:       videoID,
+			FilePath:      filePath,
+			Title:         title,
+			Duration:      duration,
+			MaxHeight:     originalHeight,
+			OutputFormat:  string(format),
+			SegmentFormat: req.SegmentFormat,
+			Preset:        req.Preset,
+			Subtitles:     req.Subtitles,
+			Encrypt:       req.Encrypt,
+		}
+
+		jobBytes, _ := json.Marshal(job)
+		if err := s.queue.Publish(s.cfg.Queue.JobsTopic, queue.Message{Key: []byte(jobKey(req.Tenant, videoID)), Value: jobBytes}); err != nil {
+			log.Printf("Failed to enqueue job for %s: %v", videoID, err)
+			s.setState(record.ID, jobstore.StateFailed, err)
+			return
+		}
+
+		log.Printf("Enqueued transcoding job for %s (%s)", videoID, title)
+	}()
+
+	return &UploadResponse{
+		Message:     "Video accepted and processing started.",
+		JobID:       record.ID,
+		VideoID:     videoID,
+		Title:       title,
+		PlaybackURL: playbackURL,
+		DashURL:     dashURL,
+		StatusURL:   "/jobs/" + record.ID,
+	}, nil
+}
+
+// writeEnqueueError answers a request whose job could not be created,
+// passing quota refusals on with their status
+func writeEnqueueError(w http.ResponseWriter, err error) {
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		http.Error(w, quotaErr.message, quotaErr.status)
+		return
+	}
+	log.Printf("Failed to create job: %v", err)
+	http.Error(w, "Failed to create job", http.StatusInternalServerError)
+}
+
+// validateSubtitlePart checks a sidecar subtitle sent with an upload
+func validateSubtitlePart(upload *FileUpload, header *multipart.FileHeader) error {
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return upload.ValidateSubtitle(file, header.Filename, header.Size)
+}
+
+// storeSubtitle saves a sidecar subtitle next to the uploads, taking its
+// language and flags from the original file name
+func (s *ServerService) storeSubtitle(header *multipart.FileHeader) (service.SubtitleSource, error) {
+	file, err := header.Open()
+	if err != nil {
+		return service.SubtitleSource{}, err
+	}
+	defer file.Close()
+
+	filePath, err := s.transcoder.StoreFile(file, header)
+	if err != nil {
+		return service.SubtitleSource{}, err
+	}
+	return service.SubtitleFromFilename(filePath, header.Filename), nil
+}
+
+// jobKey is the message key of a job. Prefixing the tenant keeps each
+// tenant's jobs together in the logs and on partitions of a keyed topic.
+func jobKey(tenant, videoID string) string {
+	if tenant == "" {
+		return videoID
+	}
+	return tenant + "/" + videoID
+}
+
+// ownsJob reports whether the caller may see a job: its tenant must match,
+// unless authentication is disabled
+func (s *ServerService) ownsJob(r *http.Request, job *jobstore.Job) bool {
+	return !s.auth.enabled() || principalFrom(r.Context()).Tenant == job.TenantID
+}
+
+// manifestURLs returns the primary playback URL for a format and, when DASH is
+// produced, the URL of the DASH manifest
+func manifestURLs(tenant, videoID string, format service.OutputFormat) (string, string) {
+	hlsURL := service.VideoURL(tenant, videoID, "master.m3u8")
+	dashURL := service.VideoURL(tenant, videoID, "manifest.mpd")
+
+	switch {
+	case !format.HasDASH():
+		return hlsURL, ""
+	case !format.HasHLS():
+		return dashURL, dashURL
+	default:
+		return hlsURL, dashURL
+	}
+}
+
+// setState records a job transition, logging rather than failing the request path
+func (s *ServerService) setState(jobID string, state jobstore.State, cause error) {
+	errMsg := ""
+	if cause != nil {
+		errMsg = cause.Error()
+	}
+
+	if err := s.jobs.UpdateState(jobID, state, errMsg); err != nil {
+		log.Printf("Failed to update job %s to %s: %v", jobID, state, err)
+	}
+
+	queue.PublishProgress(s.queue, s.cfg.Queue.ProgressTopic, queue.ProgressEvent{
+		Type:  queue.EventTypeState,
+		JobID: jobID,
+		State: string(state),
+		Error: errMsg,
+	})
+}
+
+// streamJobEvents relays a job's events as Server-Sent Events until the job
+// finishes or the client disconnects
+func (s *ServerService) streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	jobID := r.PathValue("id")
+
+	job, err := s.jobs.Get(jobID)
+	if errors.Is(err, jobstore.ErrNotFound) || err == nil && !s.ownsJob(r, job) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load job", http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reporting the current state so no transition is lost in between
+	events, unsubscribe := s.events.subscribe(jobID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent(w, flusher, stateEvent(job))
+	if job.State.Done() {
+		return
+	}
+
+	// Events can be missed while the API restarts, so the store is re-checked
+	// on every heartbeat to guarantee the stream terminates
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, flusher, event)
+			if event.Type == queue.EventTypeState && jobstore.State(event.State).Done() {
+				return
+			}
+		case <-heartbeat.C:
+			job, err := s.jobs.Get(jobID)
+			if err == nil && job.State.Done() {
+				writeEvent(w, flusher, stateEvent(job))
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
 }
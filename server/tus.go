@@ -0,0 +1,470 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/service"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusChunkType  = "application/offset+octet-stream"
+)
+
+type tusUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata"`
+	RawMeta   string            `json:"raw_metadata,omitempty"`
+	JobID     string            `json:"job_id,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// tusHandler implements the tus 1.0 core protocol with the creation and
+// termination extensions. Chunks are assembled under dir and, once complete,
+// handed to the same validation and enqueue path as multipart uploads.
+type tusHandler struct {
+	dir        string
+	uploadsDir string
+	validator  FileUpload
+	presets    *service.PresetCatalog
+	enqueue    func(filePath string, req JobRequest) (*UploadResponse, error)
+	admit      func(w http.ResponseWriter, r *http.Request, size int64) bool
+
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+// uploadLock is dropped from tusHandler.locks once no request holds or waits for it
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// errUploadRejected marks uploads whose content failed validation. Unlike
+// uploads that could not be handed off, they are discarded.
+var errUploadRejected = errors.New("upload rejected")
+
+func newTusHandler(uploadsDir string, maxSize int64, presets *service.PresetCatalog, enqueue func(filePath string, req JobRequest) (*UploadResponse, error), admit func(w http.ResponseWriter, r *http.Request, size int64) bool) *tusHandler {
+	return &tusHandler{
+		dir:        filepath.Join(uploadsDir, "tus"),
+		uploadsDir: uploadsDir,
+		validator:  FileUpload{MaxSize: maxSize},
+		presets:    presets,
+		enqueue:    enqueue,
+		admit:      admit,
+		locks:      make(map[string]*uploadLock),
+	}
+}
+
+// register adds the tus endpoints to mux. Every endpoint but the protocol
+// discovery goes through auth.
+func (t *tusHandler) register(mux *http.ServeMux, auth func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("OPTIONS /files/", t.options)
+	mux.HandleFunc("POST /files", auth(t.create))
+	mux.HandleFunc("POST /files/{$}", auth(t.create))
+	mux.HandleFunc("HEAD /files/{id}", auth(t.head))
+	mux.HandleFunc("PATCH /files/{id}", auth(t.patch))
+	mux.HandleFunc("DELETE /files/{id}", auth(t.terminate))
+}
+
+// options advertises the protocol version, extensions and size limit
+func (t *tusHandler) options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(t.validator.MaxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create reserves a new upload of a declared length
+func (t *tusHandler) create(w http.ResponseWriter, r *http.Request) {
+	if !t.checkResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > t.validator.MaxSize {
+		http.Error(w, fmt.Sprintf("Upload exceeds maximum size of %d bytes", t.validator.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := parseEncrypt(metadata["encrypt"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := uploadJobRequest(metadata["filename"], &tusUpload{Metadata: metadata}).Validate(t.presets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !t.admit(w, r, length) {
+		return
+	}
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	upload := &tusUpload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		RawMeta:   r.Header.Get("Upload-Metadata"),
+		Tenant:    principalFrom(r.Context()).Tenant,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	data, err := os.Create(t.dataPath(upload.ID))
+	if err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	data.Close()
+
+	if err := t.saveInfo(upload); err != nil {
+		os.Remove(t.dataPath(upload.ID))
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", "/files/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports how many bytes of an upload the server has received
+func (t *tusHandler) head(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	unlock := t.lock(id)
+	defer unlock()
+
+	upload, err := t.loadInfo(id, principalFrom(r.Context()).Tenant)
+	if err != nil {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	offset, err := t.offset(upload)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.RawMeta != "" {
+		w.Header().Set("Upload-Metadata", upload.RawMeta)
+	}
+	if upload.JobID != "" {
+		setJobHeaders(w, upload.JobID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends a chunk at the current offset and enqueues the video once
+// the final byte has arrived
+func (t *tusHandler) patch(w http.ResponseWriter, r *http.Request) {
+	if !t.checkResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusChunkType {
+		http.Error(w, "Content-Type must be "+tusChunkType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	id := r.PathValue("id")
+	unlock := t.lock(id)
+	defer unlock()
+
+	upload, err := t.loadInfo(id, principalFrom(r.Context()).Tenant)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	offset, err := t.offset(upload)
+	if err != nil {
+		http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if clientOffset != offset {
+		http.Error(w, fmt.Sprintf("Upload-Offset mismatch: server has %d bytes", offset), http.StatusConflict)
+		return
+	}
+
+	if upload.JobID == "" {
+		offset, err = t.appendChunk(upload, offset, r.Body)
+		if err != nil {
+			// Bytes written before the connection dropped are kept so the
+			// client can resume from the offset reported by HEAD
+			log.Printf("Partial tus chunk for upload %s: %v", id, err)
+		}
+	}
+
+	if offset == upload.Length && upload.JobID == "" {
+		// Only uploads that can never become a job are discarded. Otherwise the
+		// upload stays complete, and an empty PATCH at its final offset
+		// retries the hand-off.
+		resp, err := t.finish(upload)
+		var quotaErr *quotaError
+		switch {
+		case errors.Is(err, errUploadRejected):
+			t.remove(upload.ID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, errUnknownDuration):
+			t.remove(upload.ID)
+			http.Error(w, errUnknownDuration.message, errUnknownDuration.status)
+			return
+		case errors.As(err, &quotaErr):
+			http.Error(w, quotaErr.message, quotaErr.status)
+			return
+		case err != nil:
+			log.Printf("Failed to create job for tus upload %s: %v", id, err)
+			http.Error(w, "Failed to create job", http.StatusInternalServerError)
+			return
+		}
+		upload.JobID = resp.JobID
+		if err := t.saveInfo(upload); err != nil {
+			log.Printf("Failed to record job for tus upload %s: %v", id, err)
+		}
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if upload.JobID != "" {
+		setJobHeaders(w, upload.JobID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate discards an upload and any bytes received so far
+func (t *tusHandler) terminate(w http.ResponseWriter, r *http.Request) {
+	if !t.checkResumable(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	unlock := t.lock(id)
+	defer unlock()
+
+	if _, err := t.loadInfo(id, principalFrom(r.Context()).Tenant); err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	t.remove(id)
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t *tusHandler) appendChunk(upload *tusUpload, offset int64, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(t.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	written, err := io.Copy(f, io.LimitReader(body, upload.Length-offset))
+	return offset + written, err
+}
+
+// finish validates the assembled file, moves it next to regular uploads and enqueues it
+func (t *tusHandler) finish(upload *tusUpload) (*UploadResponse, error) {
+	filename := service.SanitizeFilename(upload.Metadata["filename"])
+
+	src := t.dataPath(upload.ID)
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	err = t.validator.ValidateSource(f, filename, upload.Length)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUploadRejected, err)
+	}
+
+	filePath := filepath.Join(t.uploadsDir, uuid.New().String()+service.SafeExt(filename))
+	if err := os.Rename(src, filePath); err != nil {
+		return nil, fmt.Errorf("failed to store upload: %v", err)
+	}
+
+	resp, err := t.enqueue(filePath, uploadJobRequest(filename, upload))
+	if err != nil {
+		// Put the data back, so the hand-off can be retried
+		if moveErr := os.Rename(filePath, src); moveErr != nil {
+			log.Printf("Failed to restore tus upload %s: %v", upload.ID, moveErr)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// offset is the number of bytes received, which equals the length once the
+// upload has been handed off and its data file moved away
+func (t *tusHandler) offset(upload *tusUpload) (int64, error) {
+	if upload.JobID != "" {
+		return upload.Length, nil
+	}
+
+	info, err := os.Stat(t.dataPath(upload.ID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (t *tusHandler) checkResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// lock serializes requests touching the same upload
+func (t *tusHandler) lock(id string) func() {
+	t.mu.Lock()
+	l, ok := t.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		t.locks[id] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		t.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(t.locks, id)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *tusHandler) remove(id string) {
+	os.Remove(t.dataPath(id))
+	os.Remove(t.infoPath(id))
+}
+
+func (t *tusHandler) loadInfo(id, tenant string) (*tusUpload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("invalid upload id")
+	}
+
+	data, err := os.ReadFile(t.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	// Other tenants' uploads are reported as missing rather than forbidden
+	if upload.Tenant != tenant {
+		return nil, os.ErrNotExist
+	}
+	return &upload, nil
+}
+
+func (t *tusHandler) saveInfo(upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.infoPath(upload.ID), data, 0644)
+}
+
+func (t *tusHandler) dataPath(id string) string {
+	return filepath.Join(t.dir, id)
+}
+
+func (t *tusHandler) infoPath(id string) string {
+	return filepath.Join(t.dir, id+".info")
+}
+
+// uploadJobRequest maps the tus Upload-Metadata keys onto job options. The
+// encrypt key was already checked when the upload was created.
+func uploadJobRequest(filename string, upload *tusUpload) JobRequest {
+	encrypt, _ := parseEncrypt(upload.Metadata["encrypt"])
+	return JobRequest{
+		OriginalFilename: filename,
+		Title:            upload.Metadata["title"],
+		OutputFormat:     upload.Metadata["format"],
+		SegmentFormat:    upload.Metadata["segment_format"],
+		Preset:           upload.Metadata["preset"],
+		Encrypt:          encrypt,
+		Tenant:           upload.Tenant,
+	}
+}
+
+func setJobHeaders(w http.ResponseWriter, jobID string) {
+	w.Header().Set("X-Job-ID", jobID)
+	w.Header().Set("X-Status-URL", "/jobs/"+jobID)
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2" pairs
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"go-transcoder/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tusTest drives a tusHandler through a mux. Requests act as the tenant in
+// their X-Test-Tenant header.
+type tusTest struct {
+	t        *testing.T
+	handler  *tusHandler
+	mux      *http.ServeMux
+	enqueued []string
+	failures int   // Enqueue attempts that fail before one succeeds
+	refusal  error // Returned by every enqueue attempt when set
+}
+
+func newTusTest(t *testing.T) *tusTest {
+	tt := &tusTest{t: t, mux: http.NewServeMux()}
+	enqueue := func(filePath string, req JobRequest) (*UploadResponse, error) {
+		if tt.refusal != nil {
+			return nil, tt.refusal
+		}
+		if tt.failures > 0 {
+			tt.failures--
+			return nil, errors.New("job store unavailable")
+		}
+		tt.enqueued = append(tt.enqueued, filePath)
+		return &UploadResponse{JobID: "job-" + strconv.Itoa(len(tt.enqueued))}, nil
+	}
+	admit := func(w http.ResponseWriter, r *http.Request, size int64) bool { return true }
+
+	tt.handler = newTusHandler(t.TempDir(), 1<<20, service.DefaultPresets(), enqueue, admit)
+	tt.handler.register(tt.mux, func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			caller := principal{Tenant: r.Header.Get("X-Test-Tenant")}
+			next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+		}
+	})
+	return tt
+}
+
+func (tt *tusTest) do(method, path, tenant string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	tt.t.Helper()
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("X-Test-Tenant", tenant)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	tt.mux.ServeHTTP(w, r)
+	return w
+}
+
+// create reserves an upload of length bytes and returns its location
+func (tt *tusTest) create(tenant, filename string, length int) string {
+	tt.t.Helper()
+	w := tt.do(http.MethodPost, "/files", tenant, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}, nil)
+	if w.Code != http.StatusCreated {
+		tt.t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/files/") {
+		tt.t.Fatalf("create: unexpected Location %q", location)
+	}
+	return location
+}
+
+func (tt *tusTest) patch(location, tenant string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	tt.t.Helper()
+	return tt.do(http.MethodPatch, location, tenant, map[string]string{
+		"Content-Type":  tusChunkType,
+		"Upload-Offset": strconv.Itoa(offset),
+	}, chunk)
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body)
+	}
+}
+
+func TestTusCreate(t *testing.T) {
+	tt := newTusTest(t)
+
+	w := tt.do(http.MethodOptions, "/files/", "", nil, nil)
+	expectStatus(t, w, http.StatusNoContent)
+	if w.Header().Get("Tus-Version") != tusVersion || w.Header().Get("Tus-Max-Size") != strconv.Itoa(1<<20) {
+		t.Errorf("unexpected discovery headers %v", w.Header())
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"created", map[string]string{"Upload-Length": "100"}, http.StatusCreated},
+		{"missing length", nil, http.StatusBadRequest},
+		{"negative length", map[string]string{"Upload-Length": "-1"}, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": strconv.Itoa(1<<20 + 1)}, http.StatusRequestEntityTooLarge},
+		{"bad metadata", map[string]string{"Upload-Length": "100", "Upload-Metadata": "filename !!!"}, http.StatusBadRequest},
+		{"unknown preset", map[string]string{"Upload-Length": "100", "Upload-Metadata": "preset " + base64.StdEncoding.EncodeToString([]byte("nope"))}, http.StatusBadRequest},
+		{"unsupported version", map[string]string{"Upload-Length": "100", "Tus-Resumable": "0.2.2"}, http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, tt.do(http.MethodPost, "/files", "", test.headers, nil), test.status)
+		})
+	}
+}
+
+func TestTusUploadInChunks(t *testing.T) {
+	tt := newTusTest(t)
+	location := tt.create("media", "clip.mp4", len(mp4Body))
+
+	w := tt.do(http.MethodHead, location, "media", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Upload-Offset") != "0" || w.Header().Get("Upload-Length") != strconv.Itoa(len(mp4Body)) {
+		t.Fatalf("unexpected HEAD of a new upload: %v", w.Header())
+	}
+
+	half := len(mp4Body) / 2
+	w = tt.patch(location, "media", 0, mp4Body[:half])
+	expectStatus(t, w, http.StatusNoContent)
+	if w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("expected offset %d, got %q", half, w.Header().Get("Upload-Offset"))
+	}
+
+	// A chunk sent for an offset the server does not have is refused
+	expectStatus(t, tt.patch(location, "media", 0, mp4Body), http.StatusConflict)
+	expectStatus(t, tt.do(http.MethodPatch, location, "media", map[string]string{"Upload-Offset": strconv.Itoa(half)}, mp4Body[half:]), http.StatusUnsupportedMediaType)
+
+	w = tt.do(http.MethodHead, location, "media", nil, nil)
+	if w.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("expected HEAD to report offset %d, got %q", half, w.Header().Get("Upload-Offset"))
+	}
+
+	w = tt.patch(location, "media", half, mp4Body[half:])
+	expectStatus(t, w, http.StatusNoContent)
+	if w.Header().Get("X-Job-ID") != "job-1" || w.Header().Get("Upload-Offset") != strconv.Itoa(len(mp4Body)) {
+		t.Fatalf("expected the completed upload to be enqueued, got %v", w.Header())
+	}
+	if len(tt.enqueued) != 1 {
+		t.Fatalf("expected one enqueued file, got %v", tt.enqueued)
+	}
+	if data, err := os.ReadFile(tt.enqueued[0]); err != nil || !bytes.Equal(data, mp4Body) {
+		t.Fatalf("enqueued file does not hold the upload (err %v)", err)
+	}
+
+	// The job is reported from then on and not created twice
+	w = tt.do(http.MethodHead, location, "media", nil, nil)
+	if w.Header().Get("X-Job-ID") != "job-1" || w.Header().Get("Upload-Offset") != strconv.Itoa(len(mp4Body)) {
+		t.Errorf("unexpected HEAD of a finished upload: %v", w.Header())
+	}
+	expectStatus(t, tt.patch(location, "media", len(mp4Body), nil), http.StatusNoContent)
+	if len(tt.enqueued) != 1 {
+		t.Errorf("expected the job to be created once, got %v", tt.enqueued)
+	}
+}
+
+func TestTusInvalidUploadIsDiscarded(t *testing.T) {
+	tt := newTusTest(t)
+	body := []byte("just some notes")
+	location := tt.create("", "notes.txt", len(body))
+
+	expectStatus(t, tt.patch(location, "", 0, body), http.StatusBadRequest)
+	expectStatus(t, tt.do(http.MethodHead, location, "", nil, nil), http.StatusNotFound)
+	if len(tt.enqueued) != 0 {
+		t.Errorf("expected nothing to be enqueued, got %v", tt.enqueued)
+	}
+}
+
+func TestTusEnqueueFailureKeepsUpload(t *testing.T) {
+	tt := newTusTest(t)
+	tt.failures = 1
+	location := tt.create("", "clip.mp4", len(mp4Body))
+
+	expectStatus(t, tt.patch(location, "", 0, mp4Body), http.StatusInternalServerError)
+
+	w := tt.do(http.MethodHead, location, "", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Upload-Offset") != strconv.Itoa(len(mp4Body)) || w.Header().Get("X-Job-ID") != "" {
+		t.Fatalf("expected the complete upload to be kept without a job, got %v", w.Header())
+	}
+
+	// An empty PATCH at the final offset retries the hand-off
+	w = tt.patch(location, "", len(mp4Body), nil)
+	expectStatus(t, w, http.StatusNoContent)
+	if w.Header().Get("X-Job-ID") != "job-1" {
+		t.Fatalf("expected the retry to create a job, got %v", w.Header())
+	}
+	if data, err := os.ReadFile(tt.enqueued[0]); err != nil || !bytes.Equal(data, mp4Body) {
+		t.Fatalf("enqueued file does not hold the upload (err %v)", err)
+	}
+}
+
+func TestTusUnknownDurationIsDiscarded(t *testing.T) {
+	tt := newTusTest(t)
+	tt.refusal = errUnknownDuration
+	location := tt.create("", "clip.mp4", len(mp4Body))
+
+	expectStatus(t, tt.patch(location, "", 0, mp4Body), http.StatusUnprocessableEntity)
+	expectStatus(t, tt.do(http.MethodHead, location, "", nil, nil), http.StatusNotFound)
+	if names := fileNames(t, tt.handler.uploadsDir); len(names) != 0 {
+		t.Errorf("expected no stored upload, got %v", names)
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	tt := newTusTest(t)
+	location := tt.create("", "clip.mp4", len(mp4Body))
+	expectStatus(t, tt.patch(location, "", 0, mp4Body[:10]), http.StatusNoContent)
+
+	expectStatus(t, tt.do(http.MethodDelete, location, "", nil, nil), http.StatusNoContent)
+	expectStatus(t, tt.do(http.MethodHead, location, "", nil, nil), http.StatusNotFound)
+	expectStatus(t, tt.patch(location, "", 10, mp4Body[10:]), http.StatusNotFound)
+	expectStatus(t, tt.do(http.MethodDelete, location, "", nil, nil), http.StatusNotFound)
+
+	entries, err := os.ReadDir(tt.handler.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the upload files to be removed, found %d", len(entries))
+	}
+}
+
+func TestTusTenantMismatch(t *testing.T) {
+	tt := newTusTest(t)
+	location := tt.create("media", "clip.mp4", len(mp4Body))
+
+	expectStatus(t, tt.do(http.MethodHead, location, "sales", nil, nil), http.StatusNotFound)
+	expectStatus(t, tt.patch(location, "sales", 0, mp4Body), http.StatusNotFound)
+	expectStatus(t, tt.do(http.MethodDelete, location, "sales", nil, nil), http.StatusNotFound)
+
+	// The owner's upload is untouched
+	w := tt.do(http.MethodHead, location, "media", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("expected no bytes from the other tenant, got offset %q", w.Header().Get("Upload-Offset"))
+	}
+}
+
+func TestTusLocksAreReleased(t *testing.T) {
+	tt := newTusTest(t)
+	location := tt.create("", "clip.mp4", len(mp4Body))
+	tt.patch(location, "", 0, mp4Body)
+	tt.do(http.MethodHead, location, "", nil, nil)
+	tt.do(http.MethodHead, "/files/00000000-0000-0000-0000-000000000000", "", nil, nil)
+	tt.do(http.MethodDelete, location, "", nil, nil)
+
+	if n := len(tt.handler.locks); n != 0 {
+		t.Errorf("expected no upload locks after the requests finished, got %d", n)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
+	"go-transcoder/service"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// rejectedDir collects dropped files that failed validation, so they are not picked up again
+const rejectedDir = "rejected"
+
+type WatcherInterface interface {
+	Run()
+}
+
+// folderWatcher polls a drop directory and enqueues every video that has
+// stopped growing, as if it had been uploaded
+type folderWatcher struct {
+	server    *ServerService
+	dir       string
+	interval  time.Duration
+	stableFor time.Duration
+	validator FileUpload
+	request   JobRequest
+	files     map[string]dropState
+}
+
+// dropState tracks a file until its size and modification time settle
+type dropState struct {
+	size        int64
+	modTime     time.Time
+	stableSince time.Time
+}
+
+func NewWatcher(transcoder service.TranscodeService, q queue.Queue, uiService service.ProgressUIService, jobs jobstore.Store, presets *service.PresetCatalog, cfg *config.Config) (WatcherInterface, error) {
+	request := JobRequest{
+		Preset:        cfg.Job.Preset,
+		OutputFormat:  cfg.Job.OutputFormat,
+		SegmentFormat: cfg.Job.SegmentFormat,
+		Encrypt:       cfg.Job.Encrypt,
+		Tenant:        cfg.Watch.Tenant,
+	}
+	if err := request.Validate(presets); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.Watch.Dir, rejectedDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create watch directory: %v", err)
+	}
+
+	server, err := newServerService(transcoder, q, uiService, jobs, presets, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &folderWatcher{
+		server:    server,
+		dir:       cfg.Watch.Dir,
+		interval:  cfg.Watch.PollInterval,
+		stableFor: cfg.Watch.StableFor,
+		validator: FileUpload{MaxSize: cfg.MaxUploadSize()},
+		request:   request,
+		files:     make(map[string]dropState),
+	}, nil
+}
+
+func (w *folderWatcher) Run() {
+	log.Printf("Watching %s for new videos", w.dir)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := w.scan(); err != nil {
+			log.Printf("Failed to scan %s: %v", w.dir, err)
+		}
+	}
+}
+
+// scan ingests the files that have been stable for long enough. Hidden files
+// are skipped, as editors and exporters commonly write to them first.
+func (w *folderWatcher) scan() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		present[name] = true
+
+		state, known := w.files[name]
+		if !known || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) || info.Size() == 0 {
+			w.files[name] = dropState{size: info.Size(), modTime: info.ModTime(), stableSince: now}
+			continue
+		}
+		if now.Sub(state.stableSince) < w.stableFor {
+			continue
+		}
+
+		delete(w.files, name)
+		w.ingest(name, info.Size())
+	}
+
+	for name := range w.files {
+		if !present[name] {
+			delete(w.files, name)
+		}
+	}
+	return nil
+}
+
+// ingest validates a dropped file, moves it into the uploads directory and enqueues it
+func (w *folderWatcher) ingest(name string, size int64) {
+	src := filepath.Join(w.dir, name)
+
+	f, err := os.Open(src)
+	if err != nil {
+		log.Printf("Failed to open %s: %v", src, err)
+		return
+	}
+	err = w.validator.ValidateSource(f, name, size)
+	f.Close()
+	if err != nil {
+		log.Printf("Rejecting %s: %v", src, err)
+		w.reject(src, name)
+		return
+	}
+
+	uploadsDir := w.server.cfg.Storage.UploadsDir
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		log.Printf("Failed to create %s: %v", uploadsDir, err)
+		return
+	}
+	dst := filepath.Join(uploadsDir, uuid.New().String()+service.SafeExt(name))
+	if err := moveFile(src, dst); err != nil {
+		log.Printf("Failed to move %s to %s: %v", src, dst, err)
+		return
+	}
+
+	req := w.request
+	req.OriginalFilename = name
+	resp, err := w.server.enqueueJob(dst, req)
+	if errors.Is(err, errUnknownDuration) {
+		log.Printf("Rejecting %s: %v", src, err)
+		w.reject(dst, name)
+		return
+	}
+	if err != nil {
+		// Put the file back, so it is retried on a later scan, such as once
+		// the tenant has quota again
+		var quotaErr *quotaError
+		if errors.As(err, &quotaErr) {
+			log.Printf("Deferring %s: %v", name, err)
+		} else {
+			log.Printf("Failed to create job for %s: %v", name, err)
+		}
+		if err := moveFile(dst, src); err != nil {
+			log.Printf("Failed to move %s back to %s: %v", dst, src, err)
+		}
+		return
+	}
+	log.Printf("Ingested %s as video %s (job %s)", name, resp.VideoID, resp.JobID)
+}
+
+// reject moves a file into the rejected directory under its dropped name
+func (w *folderWatcher) reject(path, name string) {
+	dst, err := freePath(filepath.Join(w.dir, rejectedDir), name)
+	if err == nil {
+		err = moveFile(path, dst)
+	}
+	if err != nil {
+		log.Printf("Failed to move %s aside: %v", path, err)
+	}
+}
+
+// freePath returns a path for name in dir that no file uses yet, adding a
+// counter before the extension if needed
+func freePath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		path := filepath.Join(dir, candidate)
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s in %s", name, dir)
+}
+
+// moveFile renames src to dst, copying instead when they are on different
+// file systems, as is typical for shared drop folders
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func newTestWatcher(t *testing.T, request JobRequest) *folderWatcher {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.UploadsDir = t.TempDir()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, rejectedDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &folderWatcher{
+		server:    &ServerService{cfg: &cfg},
+		dir:       dir,
+		validator: FileUpload{MaxSize: cfg.MaxUploadSize()},
+		request:   request,
+		files:     make(map[string]dropState),
+	}
+}
+
+// drop writes a file into the watched directory
+func drop(t *testing.T, w *folderWatcher, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(w.dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestWatchRejectedFilesKeepTheirPredecessors(t *testing.T) {
+	w := newTestWatcher(t, JobRequest{})
+	notes := []byte("not a video")
+
+	for i := 0; i < 3; i++ {
+		drop(t, w, "notes.txt", notes)
+		w.ingest("notes.txt", int64(len(notes)))
+	}
+
+	rejected := fileNames(t, filepath.Join(w.dir, rejectedDir))
+	if want := []string{"notes-1.txt", "notes-2.txt", "notes.txt"}; !slices.Equal(rejected, want) {
+		t.Errorf("expected rejected files %v, got %v", want, rejected)
+	}
+	if names := fileNames(t, w.dir); len(names) != 0 {
+		t.Errorf("expected the drop directory to be empty, got %v", names)
+	}
+}
+
+func TestWatchEnqueueFailureReturnsTheFile(t *testing.T) {
+	// An output format enqueueJob refuses makes every job fail to be created
+	w := newTestWatcher(t, JobRequest{OutputFormat: "bogus"})
+	drop(t, w, "clip.mp4", mp4Body)
+
+	w.ingest("clip.mp4", int64(len(mp4Body)))
+
+	if names := fileNames(t, w.dir); !slices.Equal(names, []string{"clip.mp4"}) {
+		t.Errorf("expected clip.mp4 back in the drop directory, got %v", names)
+	}
+	if names := fileNames(t, w.server.cfg.Storage.UploadsDir); len(names) != 0 {
+		t.Errorf("expected no file left in the uploads directory, got %v", names)
+	}
+}
+
+func TestFreePath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"clip.mp4", "clip-1.mp4", "README"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"new.mp4", "new.mp4"},
+		{"clip.mp4", "clip-2.mp4"},
+		{"README", "README-1"},
+	}
+	for _, tt := range tests {
+		got, err := freePath(dir, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, tt.want); got != want {
+			t.Errorf("freePath(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestWatchRejectsUnknownDurationUnderMinutesQuota(t *testing.T) {
+	w := newTestWatcher(t, JobRequest{Tenant: "media"})
+	jobs, err := jobstore.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.server.jobs = jobs
+	w.server.cfg.Quota.MaxMonthlyMinutes = 10
+	w.server.uiService = fakeProbe{err: errors.New("ffprobe failed")}
+	drop(t, w, "clip.mp4", mp4Body)
+
+	w.ingest("clip.mp4", int64(len(mp4Body)))
+
+	if names := fileNames(t, filepath.Join(w.dir, rejectedDir)); !slices.Equal(names, []string{"clip.mp4"}) {
+		t.Errorf("expected clip.mp4 to be rejected, got %v", names)
+	}
+	if names := fileNames(t, w.dir); len(names) != 0 {
+		t.Errorf("expected the drop directory to be empty, got %v", names)
+	}
+	if names := fileNames(t, w.server.cfg.Storage.UploadsDir); len(names) != 0 {
+		t.Errorf("expected no file left in the uploads directory, got %v", names)
+	}
+}
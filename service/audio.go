@@ -0,0 +1,284 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AudioTrack is an audio stream of the source
+type AudioTrack struct {
+	Index    int    // Stream index in the source
+	Language string // ISO 639-2 code, "und" when untagged
+	Title    string
+	Channels int
+	Default  bool
+}
+
+// AudioInfo describes an audio-only rendition
+type AudioInfo struct {
+	GroupID  string // One group per bitrate, holding every language
+	Track    int    // Position among the source's audio tracks, from 1
+	Bitrate  int
+	Language string
+	Name     string
+	Channels int
+	Default  bool
+}
+
+type language struct {
+	Tag  string // RFC 5646 tag used in HLS and DASH manifests
+	Name string
+}
+
+// languages maps the ISO 639-2 codes ffprobe reports to manifest tags and
+// display names; unlisted codes are passed through
+var languages = map[string]language{
+	"amh": {"am", "Amharic"},
+	"ara": {"ar", "Arabic"},
+	"chi": {"zh", "Chinese"},
+	"zho": {"zh", "Chinese"},
+	"dut": {"nl", "Dutch"},
+	"nld": {"nl", "Dutch"},
+	"eng": {"en", "English"},
+	"fre": {"fr", "French"},
+	"fra": {"fr", "French"},
+	"ger": {"de", "German"},
+	"deu": {"de", "German"},
+	"hin": {"hi", "Hindi"},
+	"ita": {"it", "Italian"},
+	"jpn": {"ja", "Japanese"},
+	"kor": {"ko", "Korean"},
+	"pol": {"pl", "Polish"},
+	"por": {"pt", "Portuguese"},
+	"rus": {"ru", "Russian"},
+	"spa": {"es", "Spanish"},
+	"swe": {"sv", "Swedish"},
+	"tur": {"tr", "Turkish"},
+}
+
+// probeAudioTracks lists the audio streams of the source in stream order
+func probeAudioTracks(inputFile string) ([]AudioTrack, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index,channels:stream_tags=language,title:stream_disposition=default",
+		"-of", "json",
+		inputFile,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			Index       int               `json:"index"`
+			Channels    int               `json:"channels"`
+			Tags        map[string]string `json:"tags"`
+			Disposition map[string]int    `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	tracks := make([]AudioTrack, 0, len(probe.Streams))
+	for _, stream := range probe.Streams {
+		tracks = append(tracks, AudioTrack{
+			Index:    stream.Index,
+			Language: normalizeLanguage(stream.Tags["language"]),
+			Title:    sanitizeAttribute(stream.Tags["title"]),
+			Channels: stream.Channels,
+			Default:  stream.Disposition["default"] == 1,
+		})
+	}
+	return tracks, nil
+}
+
+// normalizeLanguage reduces a language tag to a lowercase code that is safe in
+// folder names, returning "und" for missing or malformed tags
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len(tag) < 2 || len(tag) > 3 {
+		return "und"
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return "und"
+		}
+	}
+	return tag
+}
+
+// audioRenditions pairs every source track with every bitrate. The first
+// track flagged as default, or the first track, becomes the default of each group.
+func audioRenditions(tracks []AudioTrack, bitrates []int) []audioRendition {
+	defaultTrack := 0
+	for i, track := range tracks {
+		if track.Default {
+			defaultTrack = i
+			break
+		}
+	}
+
+	// Names repeat in every group, but must differ within one
+	names := make([]string, len(tracks))
+	seen := make(map[string]int)
+	for i, track := range tracks {
+		names[i] = track.Title
+		if names[i] == "" {
+			names[i] = languageName(track.Language, i+1)
+		}
+		names[i] = uniqueName(seen, names[i])
+	}
+
+	var renditions []audioRendition
+	for _, bitrate := range bitrates {
+		for i, track := range tracks {
+			channels := min(track.Channels, 2)
+			if channels <= 0 {
+				channels = 2
+			}
+
+			renditions = append(renditions, audioRendition{
+				FolderName: fmt.Sprintf("audio-%d-%s-%dk", i+1, track.Language, bitrate/1000),
+				Track:      track,
+				Info: AudioInfo{
+					GroupID:  fmt.Sprintf("audio-%dk", bitrate/1000),
+					Track:    i + 1,
+					Bitrate:  bitrate,
+					Language: track.Language,
+					Name:     names[i],
+					Channels: channels,
+					Default:  i == defaultTrack,
+				},
+			})
+		}
+	}
+	return renditions
+}
+
+type audioRendition struct {
+	FolderName string
+	Track      AudioTrack
+	Info       AudioInfo
+}
+
+// languageTag returns the RFC 5646 tag of a language, or "" when it is unknown
+func languageTag(code string) string {
+	if code == "und" {
+		return ""
+	}
+	if lang, ok := languages[code]; ok {
+		return lang.Tag
+	}
+	return code
+}
+
+func languageName(code string, track int) string {
+	if lang, ok := languages[code]; ok {
+		return lang.Name
+	}
+	for _, lang := range languages {
+		if lang.Tag == code {
+			return lang.Name
+		}
+	}
+	if code != "und" {
+		return strings.ToUpper(code)
+	}
+	return "Track " + strconv.Itoa(track)
+}
+
+func getAudioArgs(inputFile, outputDir string, rendition audioRendition, opts TranscodeOptions) []string {
+	args := []string{
+		"-y",
+		"-i", inputFile,
+		"-map", fmt.Sprintf("0:%d", rendition.Track.Index),
+		"-vn", "-sn", "-dn",
+		"-codec:a", "aac",
+		"-b:a", strconv.Itoa(rendition.Info.Bitrate),
+		"-ac", strconv.Itoa(rendition.Info.Channels),
+	}
+	return append(args, getHLSArgs(outputDir, opts)...)
+}
+
+// splitVariants separates the video renditions from the audio-only and subtitle ones
+func splitVariants(variants []VariantInfo) (video, audio, subtitles []VariantInfo) {
+	for _, variant := range variants {
+		switch {
+		case variant.Audio != nil:
+			audio = append(audio, variant)
+		case variant.Subtitle != nil:
+			subtitles = append(subtitles, variant)
+		default:
+			video = append(video, variant)
+		}
+	}
+	return video, audio, subtitles
+}
+
+// sortAudio orders audio renditions by source track, then by bitrate
+func sortAudio(audio []VariantInfo) {
+	sort.SliceStable(audio, func(i, j int) bool {
+		if audio[i].Audio.Track != audio[j].Audio.Track {
+			return audio[i].Audio.Track < audio[j].Audio.Track
+		}
+		return audio[i].Audio.Bitrate < audio[j].Audio.Bitrate
+	})
+}
+
+// audioGroup is the set of audio renditions sharing a GROUP-ID in HLS
+type audioGroup struct {
+	ID               string
+	Bitrate          int
+	Renditions       []VariantInfo
+	Bandwidth        int // Highest peak bitrate in the group
+	AverageBandwidth int
+	Codecs           string
+}
+
+// groupAudio collects audio renditions into groups ordered by bitrate
+func groupAudio(audio []VariantInfo) []*audioGroup {
+	var groups []*audioGroup
+	byID := make(map[string]*audioGroup)
+	for _, rendition := range audio {
+		group, ok := byID[rendition.Audio.GroupID]
+		if !ok {
+			group = &audioGroup{ID: rendition.Audio.GroupID, Bitrate: rendition.Audio.Bitrate}
+			byID[group.ID] = group
+			groups = append(groups, group)
+		}
+
+		group.Renditions = append(group.Renditions, rendition)
+		group.Bandwidth = max(group.Bandwidth, rendition.Bandwidth)
+		group.AverageBandwidth = max(group.AverageBandwidth, rendition.AverageBandwidth)
+		if group.Codecs == "" {
+			group.Codecs = rendition.Codecs
+		}
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group.Renditions, func(i, j int) bool {
+			return group.Renditions[i].Audio.Track < group.Renditions[j].Audio.Track
+		})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Bitrate < groups[j].Bitrate
+	})
+	return groups
+}
+
+// audioGroupFor spreads the video renditions, ordered by height, over the
+// audio groups, so low renditions are paired with low audio bitrates
+func audioGroupFor(groups []*audioGroup, index, videoCount int) *audioGroup {
+	if len(groups) == 0 || videoCount == 0 {
+		return nil
+	}
+	return groups[index*len(groups)/videoCount]
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// avcProfiles maps ffprobe H.264 profile names to the profile_idc and
+// constraint flag bytes used in RFC 6381 "avc1" codec strings
+var avcProfiles = map[string]string{
+	"Constrained Baseline":  "42E0",
+	"Baseline":              "4200",
+	"Main":                  "4D40",
+	"Extended":              "5800",
+	"High":                  "6400",
+	"High 10":               "6E00",
+	"High 4:2:2":            "7A00",
+	"High 4:4:4 Predictive": "F400",
+}
+
+// hevcProfiles maps ffprobe HEVC profile names to the general profile space,
+// profile_idc and compatibility flags of "hvc1" codec strings
+var hevcProfiles = map[string]string{
+	"Main":    "1.6",
+	"Main 10": "2.4",
+}
+
+var aacProfiles = map[string]string{
+	"LC":       "mp4a.40.2",
+	"HE-AAC":   "mp4a.40.5",
+	"HE-AACv2": "mp4a.40.29",
+}
+
+// probeCodecs returns the RFC 6381 CODECS value for the first video and audio
+// stream of a media file, e.g. "avc1.64001f,mp4a.40.2", or "mp4a.40.2" for
+// audio-only renditions
+func probeCodecs(mediaPath string) (string, error) {
+	args := []string{
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,profile,level",
+		"-of", "compact=p=0:nk=0",
+		mediaPath,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var video, audio string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := parseCompactLine(line)
+		switch fields["codec_type"] {
+		case "video":
+			if video == "" {
+				video = videoCodecString(fields["codec_name"], fields["profile"], fields["level"])
+			}
+		case "audio":
+			if audio == "" {
+				audio = audioCodecString(fields["codec_name"], fields["profile"])
+			}
+		}
+	}
+
+	switch {
+	case video != "" && audio != "":
+		return video + "," + audio, nil
+	case video != "":
+		return video, nil
+	case audio != "":
+		return audio, nil
+	default:
+		return "", fmt.Errorf("unsupported or missing codecs in %s", mediaPath)
+	}
+}
+
+func videoCodecString(codec, profile, level string) string {
+	levelIdc, err := strconv.Atoi(level)
+	if err != nil || levelIdc <= 0 {
+		return ""
+	}
+
+	switch codec {
+	case "h264":
+		if p, ok := avcProfiles[profile]; ok {
+			return fmt.Sprintf("avc1.%s%02x", strings.ToLower(p), levelIdc)
+		}
+	case "hevc":
+		// ffprobe reports general_level_idc, which is already 30 × the level
+		if p, ok := hevcProfiles[profile]; ok {
+			return fmt.Sprintf("hvc1.%s.L%d.B0", p, levelIdc)
+		}
+	}
+	return ""
+}
+
+func audioCodecString(codec, profile string) string {
+	switch codec {
+	case "aac":
+		return aacProfiles[profile]
+	case "mp3":
+		return "mp4a.40.34"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	}
+	return ""
+}
+
+// parseCompactLine splits ffprobe's "key=value|key=value" compact output
+func parseCompactLine(line string) map[string]string {
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(line, "|") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
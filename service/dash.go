@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GenerateDashManifest writes manifest.mpd next to the HLS master playlist.
+// CMAF renditions are referenced in place, so HLS and DASH share one set of
+// segments; MPEG-TS renditions are remuxed into fragmented MP4 under dash/.
+// Nothing is re-encoded in either case.
+func (s *transcodeService) GenerateDashManifest(videoID string, variants []VariantInfo) error {
+	videoDir, err := SafeJoin(s.storage.OutputDir, videoID)
+	if err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("no variant info available to generate DASH manifest")
+	}
+
+	variants = sortVariantsByHeight(variants)
+	if allCMAF(variants) {
+		return writeCMAFManifest(filepath.Join(videoDir, "manifest.mpd"), videoDir, variants)
+	}
+
+	if err := os.MkdirAll(filepath.Join(videoDir, "dash"), 0755); err != nil {
+		return fmt.Errorf("failed to create DASH directory: %v", err)
+	}
+
+	cmd := exec.Command("ffmpeg", getDashArgs(variants)...)
+	cmd.Dir = videoDir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.Error("ffmpeg DASH packaging failed", "videoID", videoID, "error", err, "output", string(out))
+		return fmt.Errorf("DASH packaging failed for %s: %v", videoID, err)
+	}
+
+	return nil
+}
+
+// getDashArgs builds the packaging command, run from the video directory.
+// Each source audio track gets its own adaptation set holding its bitrates.
+func getDashArgs(variants []VariantInfo) []string {
+	video, audio, _ := splitVariants(variants)
+	sortAudio(audio)
+
+	args := []string{"-y"}
+	for _, variant := range append(video, audio...) {
+		args = append(args, "-i", filepath.Join(variant.FolderName, "index.m3u8"))
+	}
+
+	for i := range video {
+		args = append(args, "-map", fmt.Sprintf("%d:v:0", i))
+	}
+
+	// Output streams are numbered in -map order, so audio follows the video streams
+	adaptationSets := []string{"id=0,streams=v"}
+	var trackStreams []string
+	for i, rendition := range audio {
+		args = append(args,
+			"-map", fmt.Sprintf("%d:a:0", len(video)+i),
+			fmt.Sprintf("-metadata:s:a:%d", i), "language="+rendition.Audio.Language,
+		)
+
+		trackStreams = append(trackStreams, strconv.Itoa(len(video)+i))
+		if i == len(audio)-1 || audio[i+1].Audio.Track != rendition.Audio.Track {
+			adaptationSets = append(adaptationSets, fmt.Sprintf("id=%d,streams=%s", len(adaptationSets), strings.Join(trackStreams, ",")))
+			trackStreams = nil
+		}
+	}
+
+	return append(args,
+		"-c", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-f", "dash",
+		"-seg_duration", "10",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", strings.Join(adaptationSets, " "),
+		"-init_seg_name", "dash/init-$RepresentationID$.m4s",
+		"-media_seg_name", "dash/chunk-$RepresentationID$-$Number%05d$.m4s",
+		"manifest.mpd",
+	)
+}
+
+type mpd struct {
+	XMLName                   xml.Name  `xml:"MPD"`
+	Xmlns                     string    `xml:"xmlns,attr"`
+	Profiles                  string    `xml:"profiles,attr"`
+	Type                      string    `xml:"type,attr"`
+	MediaPresentationDuration string    `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string    `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int                 `xml:"id,attr"`
+	ContentType      string              `xml:"contentType,attr"`
+	MimeType         string              `xml:"mimeType,attr"`
+	Lang             string              `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr"`
+	Representations  []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID          string         `xml:"id,attr"`
+	Bandwidth   int            `xml:"bandwidth,attr"`
+	Width       int            `xml:"width,attr,omitempty"`
+	Height      int            `xml:"height,attr,omitempty"`
+	Codecs      string         `xml:"codecs,attr,omitempty"`
+	SegmentList mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdSegmentList struct {
+	Timescale      int             `xml:"timescale,attr"`
+	Initialization mpdURL          `xml:"Initialization"`
+	Timeline       []mpdTimelineS  `xml:"SegmentTimeline>S"`
+	SegmentURLs    []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type mpdTimelineS struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// writeCMAFManifest describes the existing CMAF renditions with explicit
+// segment lists taken from their HLS media playlists. Video renditions share
+// one adaptation set; each source audio track gets its own.
+func writeCMAFManifest(manifestPath, videoDir string, variants []VariantInfo) error {
+	video, audio, _ := splitVariants(variants)
+	sortAudio(audio)
+
+	sets := []mpdAdaptationSet{{
+		ID:               0,
+		ContentType:      "video",
+		MimeType:         "video/mp4",
+		SegmentAlignment: true,
+	}}
+
+	duration := 0.0
+	for _, variant := range video {
+		representation, length, err := cmafRepresentation(videoDir, variant)
+		if err != nil {
+			return err
+		}
+		duration = max(duration, length)
+		sets[0].Representations = append(sets[0].Representations, representation)
+	}
+
+	for i, rendition := range audio {
+		if i == 0 || audio[i-1].Audio.Track != rendition.Audio.Track {
+			sets = append(sets, mpdAdaptationSet{
+				ID:               len(sets),
+				ContentType:      "audio",
+				MimeType:         "audio/mp4",
+				Lang:             languageTag(rendition.Audio.Language),
+				SegmentAlignment: true,
+			})
+		}
+
+		representation, length, err := cmafRepresentation(videoDir, rendition)
+		if err != nil {
+			return err
+		}
+		duration = max(duration, length)
+		set := &sets[len(sets)-1]
+		set.Representations = append(set.Representations, representation)
+	}
+
+	manifest := mpd{
+		Xmlns:                     "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:                  "urn:mpeg:dash:profile:full:2011",
+		Type:                      "static",
+		MediaPresentationDuration: fmt.Sprintf("PT%.3fS", duration),
+		MinBufferTime:             "PT10S",
+		Period: mpdPeriod{
+			ID:             "0",
+			Start:          "PT0S",
+			AdaptationSets: sets,
+		},
+	}
+
+	data, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(manifestPath, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+// cmafRepresentation lists the segments of a CMAF rendition and returns them
+// with the rendition's duration
+func cmafRepresentation(videoDir string, variant VariantInfo) (mpdRepresentation, float64, error) {
+	const timescale = 1000
+
+	playlist, err := parseMediaPlaylist(filepath.Join(videoDir, variant.FolderName, "index.m3u8"))
+	if err != nil {
+		return mpdRepresentation{}, 0, fmt.Errorf("failed to read playlist for %s: %v", variant.FolderName, err)
+	}
+	if playlist.InitURI == "" || len(playlist.Segments) == 0 {
+		return mpdRepresentation{}, 0, fmt.Errorf("playlist for %s is not a CMAF playlist", variant.FolderName)
+	}
+
+	segments := mpdSegmentList{
+		Timescale:      timescale,
+		Initialization: mpdURL{SourceURL: path.Join(variant.FolderName, playlist.InitURI)},
+	}
+	start := int64(0)
+	for i, seg := range playlist.Segments {
+		s := mpdTimelineS{D: int64(math.Round(seg.Duration * timescale))}
+		if i == 0 {
+			s.T = &start
+		}
+		segments.Timeline = append(segments.Timeline, s)
+		segments.SegmentURLs = append(segments.SegmentURLs, mpdSegmentURL{Media: path.Join(variant.FolderName, seg.URI)})
+	}
+
+	return mpdRepresentation{
+		ID:          variant.FolderName,
+		Bandwidth:   variant.Bandwidth,
+		Width:       variant.Width,
+		Height:      variant.Height,
+		Codecs:      variant.Codecs,
+		SegmentList: segments,
+	}, playlist.TotalDuration(), nil
+}
+
+func allCMAF(variants []VariantInfo) bool {
+	for _, variant := range variants {
+		if variant.SegmentFormat != SegmentFMP4 {
+			return false
+		}
+	}
+	return true
+}
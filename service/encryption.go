@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encryption enables AES-128 encryption of the HLS segments of a video
+type Encryption struct {
+	KeyURL      string // Base URL of the key endpoint; playlists reference <KeyURL>/<videoID>/<keyID>
+	RotateEvery int    // Segments per key, 0 to encrypt the whole video with one key
+}
+
+// keySize is the length of an AES-128 content key
+const keySize = 16
+
+// keyRing creates and stores the content keys of one video. Segment n of
+// every rendition is encrypted with the same key, so players can switch
+// renditions at any segment without fetching another key.
+type keyRing struct {
+	dir        string
+	videoID    string
+	encryption Encryption
+	keys       map[string][]byte
+}
+
+// newKeyRing prepares an empty key directory for a video. Keys of an earlier
+// attempt are removed, since the segments they protected were re-encoded.
+func (s *transcodeService) newKeyRing(videoID string, encryption Encryption) (*keyRing, error) {
+	dir, err := SafeJoin(s.storage.KeysDir, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear keys of %s: %v", videoID, err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory %s: %v", dir, err)
+	}
+
+	return &keyRing{
+		dir:        dir,
+		videoID:    videoID,
+		encryption: encryption,
+		keys:       make(map[string][]byte),
+	}, nil
+}
+
+// keyID names the key of the segment at index
+func (k *keyRing) keyID(index int) string {
+	if k.encryption.RotateEvery > 0 {
+		return fmt.Sprintf("key-%03d", index/k.encryption.RotateEvery)
+	}
+	return "key-000"
+}
+
+// startsKey reports whether the segment at index is the first one under its key
+func (k *keyRing) startsKey(index int) bool {
+	return index == 0 || k.encryption.RotateEvery > 0 && index%k.encryption.RotateEvery == 0
+}
+
+func (k *keyRing) keyURI(index int) string {
+	return strings.TrimSuffix(k.encryption.KeyURL, "/") + "/" + k.videoID + "/" + k.keyID(index)
+}
+
+// key returns the key of the segment at index, generating and storing it on first use
+func (k *keyRing) key(index int) ([]byte, error) {
+	id := k.keyID(index)
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(k.dir, id), key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to store key %s: %v", id, err)
+	}
+	k.keys[id] = key
+	return key, nil
+}
+
+// encryptRenditions encrypts the segments of the given renditions in place
+// and adds EXT-X-KEY tags to their playlists. Initialization segments are
+// left in the clear, as no key tag precedes their EXT-X-MAP.
+func (s *transcodeService) encryptRenditions(videoID string, folderNames []string, encryption Encryption) error {
+	ring, err := s.newKeyRing(videoID, encryption)
+	if err != nil {
+		return err
+	}
+
+	for _, folderName := range folderNames {
+		renditionDir, err := SafeJoin(s.storage.OutputDir, videoID, folderName)
+		if err != nil {
+			return err
+		}
+		if err := encryptRendition(renditionDir, ring); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", folderName, err)
+		}
+	}
+	return nil
+}
+
+func encryptRendition(renditionDir string, ring *keyRing) error {
+	playlistPath := filepath.Join(renditionDir, "index.m3u8")
+	playlist, err := parseMediaPlaylist(playlistPath)
+	if err != nil {
+		return err
+	}
+
+	for i, seg := range playlist.Segments {
+		key, err := ring.key(i)
+		if err != nil {
+			return err
+		}
+		if err := encryptSegment(filepath.Join(renditionDir, seg.URI), key, playlist.MediaSequence+i); err != nil {
+			return err
+		}
+	}
+	return addKeyTags(playlistPath, ring)
+}
+
+// encryptSegment encrypts a segment in place with AES-128-CBC and PKCS7
+// padding. The IV is the segment's media sequence number, which players
+// derive themselves when EXT-X-KEY carries no IV.
+func encryptSegment(path string, key []byte, sequence int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	// Replace the segment atomically so an interrupted job never leaves it half encrypted
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// addKeyTags inserts an EXT-X-KEY tag before the first segment under each key
+func addKeyTags(playlistPath string, ring *keyRing) error {
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	segment := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#EXTINF:"):
+			if ring.startsKey(segment) {
+				fmt.Fprintf(&b, "#EXT-X-KEY:METHOD=AES-128,URI=\"%s\"\n", ring.keyURI(segment))
+			}
+		case trimmed != "" && !strings.HasPrefix(trimmed, "#"):
+			segment++
+		}
+		b.WriteString(line)
+	}
+
+	return os.WriteFile(playlistPath, []byte(b.String()), 0644)
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKeyRing(t *testing.T, rotateEvery int) *keyRing {
+	return &keyRing{
+		dir:        t.TempDir(),
+		videoID:    "video",
+		encryption: Encryption{KeyURL: "/keys/", RotateEvery: rotateEvery},
+		keys:       make(map[string][]byte),
+	}
+}
+
+// decryptSegment reverses encryptSegment the way an HLS player does: AES-128-CBC
+// with the media sequence number as IV, then PKCS7 unpadding
+func decryptSegment(t *testing.T, data, key []byte, sequence int) []byte {
+	t.Helper()
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("encrypted size %d is not a positive multiple of the block size", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding < 1 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		t.Fatalf("invalid PKCS7 padding %x", plain[len(plain)-aes.BlockSize:])
+	}
+	return plain[:len(plain)-padding]
+}
+
+func TestEncryptSegment(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, keySize)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 188 * 7} {
+		path := filepath.Join(t.TempDir(), "seg_000.ts")
+		plain := bytes.Repeat([]byte{0x47}, size)
+		if err := os.WriteFile(path, plain, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := encryptSegment(path, key, 7); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// PKCS7 always pads, by a whole block for aligned input
+		if want := (size/aes.BlockSize + 1) * aes.BlockSize; len(data) != want {
+			t.Errorf("size %d: expected %d encrypted bytes, got %d", size, want, len(data))
+		}
+		if got := decryptSegment(t, data, key, 7); !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted segment differs from the original", size)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("size %d: temporary file left behind", size)
+		}
+	}
+}
+
+func TestEncryptSegmentIVIsTheSequenceNumber(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, keySize)
+	plain := []byte("same plaintext in every segment")
+
+	encrypted := make(map[string]bool)
+	for _, sequence := range []int{0, 1, 256} {
+		path := filepath.Join(t.TempDir(), "seg.ts")
+		if err := os.WriteFile(path, plain, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := encryptSegment(path, key, sequence); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		encrypted[string(data)] = true
+
+		if got := decryptSegment(t, data, key, sequence); !bytes.Equal(got, plain) {
+			t.Errorf("sequence %d: segment does not decrypt with the sequence number as IV", sequence)
+		}
+	}
+	if len(encrypted) != 3 {
+		t.Error("expected every sequence number to give a different ciphertext")
+	}
+}
+
+const testMediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:3
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:6.000000,
+seg_000.ts
+#EXTINF:6.000000,
+seg_001.ts
+#EXTINF:6.000000,
+seg_002.ts
+#EXTINF:2.500000,
+seg_003.ts
+#EXT-X-ENDLIST
+`
+
+func TestAddKeyTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotateEvery int
+		keys        []string // Key IDs in tag order
+		segments    []int    // Segments each tag precedes
+	}{
+		{"one key", 0, []string{"key-000"}, []int{0}},
+		{"rotation", 2, []string{"key-000", "key-001"}, []int{0, 2}},
+		{"key per segment", 1, []string{"key-000", "key-001", "key-002", "key-003"}, []int{0, 1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "index.m3u8")
+			if err := os.WriteFile(path, []byte(testMediaPlaylist), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := addKeyTags(path, newTestKeyRing(t, tt.rotateEvery)); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			lines := strings.Split(string(data), "\n")
+
+			var keys []string
+			var segments []int
+			segment := 0
+			for i, line := range lines {
+				if uri, ok := strings.CutPrefix(line, `#EXT-X-KEY:METHOD=AES-128,URI="/keys/video/`); ok {
+					keys = append(keys, strings.TrimSuffix(uri, `"`))
+					segments = append(segments, segment)
+					if !strings.HasPrefix(lines[i+1], "#EXTINF:") {
+						t.Errorf("key tag not followed by a segment: %q", lines[i+1])
+					}
+				}
+				if strings.HasPrefix(line, "seg_") {
+					segment++
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+				t.Errorf("expected keys %v, got %v", tt.keys, keys)
+			}
+			for i := range tt.segments {
+				if i >= len(segments) || segments[i] != tt.segments[i] {
+					t.Errorf("expected key tags before segments %v, got %v", tt.segments, segments)
+					break
+				}
+			}
+			if strings.Contains(string(data), "IV=") {
+				t.Error("key tags must not carry an IV, players derive it from the sequence number")
+			}
+		})
+	}
+}
+
+func TestEncryptRendition(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(testMediaPlaylist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := make([][]byte, 4)
+	for i := range plain {
+		plain[i] = bytes.Repeat([]byte{byte(i)}, 100+i*50)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("seg_%03d.ts", i)), plain[i], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ring := newTestKeyRing(t, 2)
+	if err := encryptRendition(dir, ring); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range plain {
+		key, err := os.ReadFile(filepath.Join(ring.dir, ring.keyID(i)))
+		if err != nil {
+			t.Fatalf("segment %d: key not stored: %v", i, err)
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, fmt.Sprintf("seg_%03d.ts", i)))
+		// The playlist starts at media sequence 3
+		if got := decryptSegment(t, data, key, 3+i); !bytes.Equal(got, plain[i]) {
+			t.Errorf("segment %d does not decrypt with key %s", i, ring.keyID(i))
+		}
+	}
+	if len(ring.keys) != 2 {
+		t.Errorf("expected two keys, got %d", len(ring.keys))
+	}
+	info, err := os.Stat(filepath.Join(ring.dir, "key-000"))
+	if err != nil || info.Size() != keySize || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a private %d byte key file, got %v (%v)", keySize, info, err)
+	}
+}
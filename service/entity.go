@@ -1 +1,80 @@
 package service
+
+import "fmt"
+
+// OutputFormat selects which streaming manifests a job produces
+type OutputFormat string
+
+const (
+	FormatHLS  OutputFormat = "hls"
+	FormatDASH OutputFormat = "dash"
+	FormatBoth OutputFormat = "both"
+)
+
+// ParseOutputFormat validates a requested format, defaulting to HLS
+func ParseOutputFormat(value string) (OutputFormat, error) {
+	switch OutputFormat(value) {
+	case "":
+		return FormatHLS, nil
+	case FormatHLS, FormatDASH, FormatBoth:
+		return OutputFormat(value), nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q (use hls, dash or both)", value)
+	}
+}
+
+func (f OutputFormat) HasHLS() bool {
+	return f == FormatHLS || f == FormatBoth || f == ""
+}
+
+func (f OutputFormat) HasDASH() bool {
+	return f == FormatDASH || f == FormatBoth
+}
+
+// CheckEncryption rejects formats that cannot play AES-128 encrypted
+// segments. DASH reuses the HLS segments, and DASH players do not decrypt
+// whole-segment AES-128.
+func (f OutputFormat) CheckEncryption() error {
+	if f.HasDASH() {
+		return fmt.Errorf("encryption is not supported for output format %q (use hls)", f)
+	}
+	return nil
+}
+
+// SegmentFormat selects the container used for media segments
+type SegmentFormat string
+
+const (
+	SegmentTS   SegmentFormat = "ts"
+	SegmentFMP4 SegmentFormat = "fmp4"
+)
+
+// ParseSegmentFormat validates a requested segment format, defaulting to MPEG-TS
+func ParseSegmentFormat(value string) (SegmentFormat, error) {
+	switch SegmentFormat(value) {
+	case "":
+		return SegmentTS, nil
+	case SegmentTS, SegmentFMP4:
+		return SegmentFormat(value), nil
+	default:
+		return "", fmt.Errorf("unsupported segment format %q (use ts or fmp4)", value)
+	}
+}
+
+// Extension returns the file extension of media segments in this format
+func (f SegmentFormat) Extension() string {
+	if f == SegmentFMP4 {
+		return ".m4s"
+	}
+	return ".ts"
+}
+
+// TranscodeOptions tunes how StartTranscoding encodes and segments renditions
+type TranscodeOptions struct {
+	SegmentFormat   SegmentFormat
+	VideoCodec      string           // libx264 when empty
+	AudioBitrates   []int            // bits/s, one rendition per bitrate and source audio track; 128k when empty
+	SegmentDuration int              // seconds, 10 when 0
+	Subtitles       []SubtitleSource // Sidecar files, converted along with the source's own text subtitles
+	Encryption      *Encryption      // AES-128 encryption of the HLS segments; nil leaves them in the clear
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PermanentError marks a failure that retrying cannot fix, such as corrupt or
+// unsupported input. Any other error is assumed to be transient.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err as a PermanentError
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, is permanent
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// checkSource makes sure the input exists and holds a decodable video stream
+// before any encoder is started. Rejections are permanent, while a missing or
+// crashing ffprobe is left transient.
+func checkSource(inputFile string) error {
+	if _, err := os.Stat(inputFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Permanent(fmt.Errorf("source %s does not exist", inputFile))
+		}
+		return err
+	}
+
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_type",
+		"-of", "csv=p=0",
+		inputFile,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return Permanent(fmt.Errorf("source is not a readable video: %s", strings.TrimSpace(string(exitErr.Stderr))))
+	}
+	if err != nil {
+		return fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	if strings.TrimSpace(string(out)) != "video" {
+		return Permanent(errors.New("source has no video stream"))
+	}
+	return nil
+}
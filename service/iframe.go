@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// iframePlaylistName is the I-frame-only playlist written next to each rendition's index.m3u8
+const iframePlaylistName = "iframe_index.m3u8"
+
+// iframeSegment addresses the keyframe that opens a media segment
+type iframeSegment struct {
+	URI      string
+	Duration float64
+	Length   int64 // Bytes from the start of the segment to the end of the keyframe
+}
+
+// writeIFramePlaylist indexes the keyframe at the start of every segment of a
+// rendition, which the encoder forces on each segment boundary, and writes an
+// I-frame-only playlist with byte ranges into the existing segments. It
+// returns the peak bitrate of the I-frame stream.
+func writeIFramePlaylist(renditionDir string, format SegmentFormat) (int, error) {
+	playlist, err := parseMediaPlaylist(filepath.Join(renditionDir, "index.m3u8"))
+	if err != nil {
+		return 0, err
+	}
+	if len(playlist.Segments) == 0 {
+		return 0, errors.New("rendition has no segments")
+	}
+
+	var initSize int64
+	if format == SegmentFMP4 {
+		info, err := os.Stat(filepath.Join(renditionDir, fmp4InitName))
+		if err != nil {
+			return 0, err
+		}
+		initSize = info.Size()
+	}
+
+	segments := make([]iframeSegment, 0, len(playlist.Segments))
+	peak, targetDuration := 0, 0.0
+	for _, seg := range playlist.Segments {
+		segmentPath := filepath.Join(renditionDir, seg.URI)
+
+		var length int64
+		if format == SegmentFMP4 {
+			length, err = fragmentKeyframeEnd(filepath.Join(renditionDir, fmp4InitName), segmentPath, initSize)
+		} else {
+			length, err = transportKeyframeEnd(segmentPath)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to index %s: %v", seg.URI, err)
+		}
+
+		segments = append(segments, iframeSegment{URI: seg.URI, Duration: seg.Duration, Length: length})
+		if seg.Duration > 0 {
+			peak = max(peak, int(float64(length*8)/seg.Duration))
+		}
+		targetDuration = max(targetDuration, seg.Duration)
+	}
+
+	// Byte ranges need version 4; fragmented MP4 needs 7, as in ffmpeg's own playlists
+	version := 4
+	if format == SegmentFMP4 {
+		version = 7
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-I-FRAMES-ONLY\n",
+		version, int(math.Ceil(targetDuration)))
+	if playlist.InitURI != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", playlist.InitURI)
+	}
+	for _, seg := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n#EXT-X-BYTERANGE:%d@0\n%s\n", seg.Duration, seg.Length, seg.URI)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := os.WriteFile(filepath.Join(renditionDir, iframePlaylistName), []byte(b.String()), 0644); err != nil {
+		return 0, err
+	}
+	return peak, nil
+}
+
+// transportKeyframeEnd returns the offset at which the next video packet of an
+// MPEG-TS segment starts, so that the range from 0 holds the program tables
+// and the complete first keyframe
+func transportKeyframeEnd(segmentPath string) (int64, error) {
+	packets, err := probeVideoPackets(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(packets) == 0 || !packets[0].Key {
+		return 0, errors.New("segment does not start with a keyframe")
+	}
+	if len(packets) > 1 {
+		return packets[1].Pos, nil
+	}
+
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// fragmentKeyframeEnd returns the offset just past the first keyframe sample
+// of a CMAF fragment. Fragments cannot be probed on their own, so they are
+// read behind their init segment and the offsets shifted back.
+func fragmentKeyframeEnd(initPath, segmentPath string, initSize int64) (int64, error) {
+	packets, err := probeVideoPackets("concat:" + initPath + "|" + segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(packets) == 0 || !packets[0].Key {
+		return 0, errors.New("fragment does not start with a keyframe")
+	}
+
+	end := packets[0].Pos + packets[0].Size - initSize
+	if end <= 0 {
+		return 0, fmt.Errorf("keyframe offset %d lies outside the fragment", packets[0].Pos)
+	}
+	return end, nil
+}
+
+type videoPacket struct {
+	Pos  int64
+	Size int64
+	Key  bool
+}
+
+// probeVideoPackets lists the byte positions of the video packets of a file
+func probeVideoPackets(input string) ([]videoPacket, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pos,size,flags",
+		"-of", "compact=p=0:nk=0",
+		input,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var packets []videoPacket
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := parseCompactLine(line)
+		pos, err := strconv.ParseInt(fields["pos"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("packet without position: %q", line)
+		}
+		size, _ := strconv.ParseInt(fields["size"], 10, 64)
+		packets = append(packets, videoPacket{Pos: pos, Size: size, Key: strings.HasPrefix(fields["flags"], "K")})
+	}
+	return packets, nil
+}
+
+// videoCodecs drops the audio codecs from a CODECS value, since I-frame
+// streams carry no audio
+func videoCodecs(codecs string) string {
+	video, _, _ := strings.Cut(codecs, ",")
+	return video
+}
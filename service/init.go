@@ -1,14 +1,18 @@
 package service
 
+import "go-transcoder/config"
+
 type Service struct {
 	ProgressUI ProgressUIService
 	Transcode  TranscodeService
+	Presets    *PresetCatalog
 }
 
-func InitService() *Service {
+func InitService(cfg *config.Config, presets *PresetCatalog) *Service {
 	progressUI := NewProgressUI()
 	return &Service{
 		ProgressUI: progressUI,
-		Transcode:  NewTranscodeService(progressUI),
+		Transcode:  NewTranscodeService(progressUI, cfg.Storage),
+		Presets:    presets,
 	}
 }
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type mediaSegment struct {
+	URI      string
+	Duration float64
+}
+
+// mediaPlaylist is the subset of an HLS media playlist needed to reuse its
+// segments in other manifests
+type mediaPlaylist struct {
+	InitURI       string
+	MediaSequence int // Sequence number of the first segment
+	Segments      []mediaSegment
+}
+
+func (p *mediaPlaylist) TotalDuration() float64 {
+	total := 0.0
+	for _, seg := range p.Segments {
+		total += seg.Duration
+	}
+	return total
+}
+
+// parseMediaPlaylist reads the segment URIs and durations of a VOD media playlist
+func parseMediaPlaylist(path string) (*mediaPlaylist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	playlist := &mediaPlaylist{}
+	pending := -1.0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			value, _, _ = strings.Cut(value, ",")
+			duration, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXTINF in %s: %q", path, line)
+			}
+			pending = duration
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence in %s: %q", path, line)
+			}
+			playlist.MediaSequence = sequence
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			playlist.InitURI = attributeValue(strings.TrimPrefix(line, "#EXT-X-MAP:"), "URI")
+		case strings.HasPrefix(line, "#"):
+		default:
+			if pending < 0 {
+				return nil, fmt.Errorf("segment %q in %s has no EXTINF", line, path)
+			}
+			playlist.Segments = append(playlist.Segments, mediaSegment{URI: line, Duration: pending})
+			pending = -1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return playlist, nil
+}
+
+// attributeValue extracts a (possibly quoted) attribute from an HLS attribute list
+func attributeValue(attrs, name string) string {
+	for attrs != "" {
+		var key, value string
+		key, attrs, _ = strings.Cut(attrs, "=")
+		if strings.HasPrefix(attrs, "\"") {
+			end := strings.Index(attrs[1:], "\"")
+			if end < 0 {
+				return ""
+			}
+			value = attrs[1 : end+1]
+			attrs = strings.TrimPrefix(attrs[end+2:], ",")
+		} else {
+			value, attrs, _ = strings.Cut(attrs, ",")
+		}
+
+		if strings.TrimSpace(key) == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// segmentBitrates measures the peak and average bitrate of a rendition from
+// the sizes of all of its segments, as HLS defines BANDWIDTH and
+// AVERAGE-BANDWIDTH
+func segmentBitrates(renditionDir string) (peak, average int, err error) {
+	playlist, err := parseMediaPlaylist(filepath.Join(renditionDir, "index.m3u8"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var totalBytes int64
+	for _, seg := range playlist.Segments {
+		info, err := os.Stat(filepath.Join(renditionDir, seg.URI))
+		if err != nil {
+			return 0, 0, err
+		}
+		totalBytes += info.Size()
+		if seg.Duration > 0 {
+			peak = max(peak, int(float64(info.Size()*8)/seg.Duration))
+		}
+	}
+
+	total := playlist.TotalDuration()
+	if total <= 0 {
+		return 0, 0, fmt.Errorf("rendition in %s has no duration", renditionDir)
+	}
+	return peak, int(float64(totalBytes*8) / total), nil
+}
+
+// probeFrameRate returns the average frame rate of the first video stream
+func probeFrameRate(mediaPath string) (float64, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=avg_frame_rate,r_frame_rate",
+		"-of", "compact=p=0:nk=0",
+		mediaPath,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	fields := parseCompactLine(strings.TrimSpace(string(out)))
+	for _, key := range []string{"avg_frame_rate", "r_frame_rate"} {
+		if rate := parseRational(fields[key]); rate > 0 {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown frame rate in %s", mediaPath)
+}
+
+// parseRational parses an ffprobe ratio such as "30000/1001", returning 0 for
+// unknown values like "0/0"
+func parseRational(value string) float64 {
+	num, den, ok := strings.Cut(value, "/")
+	if !ok {
+		rate, _ := strconv.ParseFloat(value, 64)
+		return rate
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// Rung is one rendition of an encoding ladder
+type Rung struct {
+	Name         string `json:"name"`          // Output folder, e.g. "720p"
+	Height       int    `json:"height"`        // Target height; width keeps the aspect ratio
+	VideoBitrate int    `json:"video_bitrate"` // Target bitrate in bits/s, 0 for pure CRF
+	MaxBitrate   int    `json:"max_bitrate"`   // VBV peak in bits/s, 0 for unconstrained
+	BufferSize   int    `json:"buffer_size"`   // VBV buffer in bits, required with max_bitrate
+	Profile      string `json:"profile"`
+	Level        string `json:"level"`
+	CRF          int    `json:"crf"` // Constant rate factor, 0 to let the bitrate drive quality
+}
+
+// Preset is a named encoding ladder plus the settings shared by all its rungs
+type Preset struct {
+	Name            string     `json:"-"`
+	VideoCodec      string     `json:"video_codec"`      // libx264 (default) or libx265
+	AudioBitrate    int        `json:"audio_bitrate"`    // bits/s, shorthand for a single audio_bitrates entry
+	AudioBitrates   []int      `json:"audio_bitrates"`   // bits/s, one audio rendition group per bitrate
+	SegmentDuration int        `json:"segment_duration"` // Seconds per segment
+	Rungs           []Rung     `json:"rungs"`
+	Trickplay       *Trickplay `json:"trickplay,omitempty"` // Scrubbing previews; nil disables them
+}
+
+// Trickplay lays out scrubbing preview frames on sprite sheets
+type Trickplay struct {
+	Interval int `json:"interval"` // Seconds between frames
+	Width    int `json:"width"`    // Frame width in pixels; height keeps the aspect ratio
+	Columns  int `json:"columns"`
+	Rows     int `json:"rows"`
+}
+
+// PresetCatalog holds every preset loaded at startup
+type PresetCatalog struct {
+	Default string             `json:"default"`
+	Presets map[string]*Preset `json:"presets"`
+}
+
+var levelPattern = regexp.MustCompile(`^[1-6](\.[0-3])?$`)
+
+// codecProfiles maps the profiles of each codec to the pixel format they
+// encode. Without a profile the encoder keeps the source's pixel format.
+var codecProfiles = map[string]map[string]string{
+	"libx264": {"": "", "baseline": "yuv420p", "main": "yuv420p", "high": "yuv420p"},
+	"libx265": {"": "", "main": "yuv420p", "main10": "yuv420p10le"},
+}
+
+// pixelFormat is the pixel format a profile requires, or "" to keep the source's
+func pixelFormat(codec, profile string) string {
+	return codecProfiles[codec][profile]
+}
+
+// DefaultPresets mirrors the original hardcoded 360p to 4K ladder
+func DefaultPresets() *PresetCatalog {
+	rungs := []Rung{
+		{Name: "360p", Height: 360},
+		{Name: "720p", Height: 720},
+		{Name: "1080p", Height: 1080},
+		{Name: "1440p", Height: 1440}, // 2K / QHD
+		{Name: "2160p", Height: 2160}, // 4K / UHD
+	}
+
+	catalog := &PresetCatalog{
+		Default: "default",
+		Presets: map[string]*Preset{
+			"default": {VideoCodec: "libx264", SegmentDuration: 10, Rungs: rungs, Trickplay: &Trickplay{}},
+		},
+	}
+	// Validate only fills in defaults here; the built-in ladder is always valid
+	catalog.Validate()
+	return catalog
+}
+
+// LoadPresets reads and validates a JSON preset file
+func LoadPresets(path string) (*PresetCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog PresetCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse presets %s: %v", path, err)
+	}
+	if err := catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid presets %s: %v", path, err)
+	}
+	return &catalog, nil
+}
+
+// Validate checks every preset, fills in defaults and sorts rungs by height
+func (c *PresetCatalog) Validate() error {
+	if len(c.Presets) == 0 {
+		return errors.New("at least one preset is required")
+	}
+	if _, ok := c.Presets[c.Default]; !ok {
+		return fmt.Errorf("default preset %q is not defined", c.Default)
+	}
+
+	for name, preset := range c.Presets {
+		if preset == nil {
+			return fmt.Errorf("preset %q is empty", name)
+		}
+		preset.Name = name
+		if err := preset.validate(); err != nil {
+			return fmt.Errorf("preset %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the named preset, or the default preset for an empty name
+func (c *PresetCatalog) Get(name string) (*Preset, error) {
+	if name == "" {
+		name = c.Default
+	}
+
+	preset, ok := c.Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+	return preset, nil
+}
+
+// RungsFor returns the rungs that do not upscale a source of the given height.
+// A source smaller than every rung is encoded once at its own height, using
+// the settings of the lowest rung.
+func (p *Preset) RungsFor(sourceHeight int) []Rung {
+	rungs := make([]Rung, 0, len(p.Rungs))
+	for _, rung := range p.Rungs {
+		if rung.Height <= sourceHeight {
+			rungs = append(rungs, rung)
+		}
+	}
+
+	if len(rungs) == 0 && len(p.Rungs) > 0 {
+		original := p.Rungs[0]
+		original.Name = "original"
+		original.Height = sourceHeight - sourceHeight%2
+		rungs = append(rungs, original)
+	}
+	return rungs
+}
+
+// TranscodeOptions returns the StartTranscoding options implied by the preset
+func (p *Preset) TranscodeOptions(segmentFormat SegmentFormat) TranscodeOptions {
+	return TranscodeOptions{
+		SegmentFormat:   segmentFormat,
+		VideoCodec:      p.VideoCodec,
+		AudioBitrates:   p.AudioBitrates,
+		SegmentDuration: p.SegmentDuration,
+	}
+}
+
+// CheckSegmentFormat rejects combinations players cannot handle, such as HEVC in MPEG-TS
+func (p *Preset) CheckSegmentFormat(segmentFormat SegmentFormat) error {
+	if p.VideoCodec == "libx265" && segmentFormat != SegmentFMP4 {
+		return fmt.Errorf("preset %q encodes HEVC, which requires segment_format fmp4", p.Name)
+	}
+	return nil
+}
+
+func (p *Preset) validate() error {
+	if p.VideoCodec == "" {
+		p.VideoCodec = "libx264"
+	}
+	profiles, ok := codecProfiles[p.VideoCodec]
+	if !ok {
+		return fmt.Errorf("unsupported video_codec %q", p.VideoCodec)
+	}
+
+	if p.SegmentDuration == 0 {
+		p.SegmentDuration = 10
+	}
+	if p.SegmentDuration < 1 || p.SegmentDuration > 30 {
+		return fmt.Errorf("segment_duration must be between 1 and 30 seconds, got %d", p.SegmentDuration)
+	}
+	if p.AudioBitrate < 0 {
+		return errors.New("audio_bitrate must not be negative")
+	}
+	if len(p.AudioBitrates) == 0 {
+		p.AudioBitrates = []int{64000, 128000}
+		if p.AudioBitrate > 0 {
+			p.AudioBitrates = []int{p.AudioBitrate}
+		}
+	}
+	for _, bitrate := range p.AudioBitrates {
+		if bitrate < 16000 || bitrate > 512000 {
+			return fmt.Errorf("audio bitrate %d must be between 16000 and 512000 bits/s", bitrate)
+		}
+	}
+	sort.Ints(p.AudioBitrates)
+	p.AudioBitrates = slices.Compact(p.AudioBitrates)
+	if len(p.Rungs) == 0 {
+		return errors.New("at least one rung is required")
+	}
+	if p.Trickplay != nil {
+		if err := p.Trickplay.validate(); err != nil {
+			return fmt.Errorf("trickplay: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, rung := range p.Rungs {
+		if err := ValidateID(rung.Name); err != nil {
+			return fmt.Errorf("rung name: %v", err)
+		}
+		if seen[rung.Name] {
+			return fmt.Errorf("duplicate rung %q", rung.Name)
+		}
+		seen[rung.Name] = true
+
+		switch {
+		case rung.Height <= 0 || rung.Height%2 != 0:
+			return fmt.Errorf("rung %q: height must be a positive even number", rung.Name)
+		case rung.VideoBitrate < 0 || rung.MaxBitrate < 0 || rung.BufferSize < 0:
+			return fmt.Errorf("rung %q: bitrates must not be negative", rung.Name)
+		case rung.MaxBitrate > 0 && rung.MaxBitrate < rung.VideoBitrate:
+			return fmt.Errorf("rung %q: max_bitrate must be at least video_bitrate", rung.Name)
+		case rung.MaxBitrate > 0 && rung.BufferSize == 0:
+			return fmt.Errorf("rung %q: buffer_size is required with max_bitrate", rung.Name)
+		case rung.CRF < 0 || rung.CRF > 51:
+			return fmt.Errorf("rung %q: crf must be between 0 and 51", rung.Name)
+		case !hasProfile(profiles, rung.Profile):
+			return fmt.Errorf("rung %q: profile %q is not supported by %s", rung.Name, rung.Profile, p.VideoCodec)
+		case rung.Level != "" && !levelPattern.MatchString(rung.Level):
+			return fmt.Errorf("rung %q: invalid level %q", rung.Name, rung.Level)
+		}
+	}
+
+	sort.SliceStable(p.Rungs, func(i, j int) bool {
+		return p.Rungs[i].Height < p.Rungs[j].Height
+	})
+	return nil
+}
+
+func hasProfile(profiles map[string]string, profile string) bool {
+	_, ok := profiles[profile]
+	return ok
+}
+
+// validate fills in a 10x10 grid of 160px frames every 10 seconds by default
+func (t *Trickplay) validate() error {
+	if t.Interval == 0 {
+		t.Interval = 10
+	}
+	if t.Width == 0 {
+		t.Width = 160
+	}
+	if t.Columns == 0 {
+		t.Columns = 10
+	}
+	if t.Rows == 0 {
+		t.Rows = 10
+	}
+
+	switch {
+	case t.Interval < 1 || t.Interval > 600:
+		return errors.New("interval must be between 1 and 600 seconds")
+	case t.Width < 32 || t.Width > 640 || t.Width%2 != 0:
+		return errors.New("width must be an even number between 32 and 640")
+	case t.Columns < 1 || t.Rows < 1 || t.Columns*t.Rows > 400:
+		return errors.New("columns and rows must be positive, with at most 400 frames per sheet")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShippedPresets(t *testing.T) {
+	catalog, err := LoadPresets("../presets.json")
+	if err != nil {
+		t.Fatalf("shipped presets are invalid: %v", err)
+	}
+
+	for name, preset := range catalog.Presets {
+		opts := preset.TranscodeOptions(SegmentFMP4)
+		for _, rung := range preset.Rungs {
+			args := getFFmpegArgs("in.mp4", "out", rung, opts)
+			if rung.Profile != "" && !slices.Contains(args, "-pix_fmt") {
+				t.Errorf("preset %s rung %s: profile %s without a pixel format: %v", name, rung.Name, rung.Profile, args)
+			}
+		}
+	}
+}
+
+func TestFFmpegPixelFormat(t *testing.T) {
+	tests := []struct {
+		codec   string
+		profile string
+		want    string // Empty when no pixel format is forced
+	}{
+		{"libx264", "", ""},
+		{"libx264", "baseline", "yuv420p"},
+		{"libx264", "high", "yuv420p"},
+		{"libx265", "main", "yuv420p"},
+		{"libx265", "main10", "yuv420p10le"},
+	}
+
+	for _, tt := range tests {
+		args := getFFmpegArgs("in.mp4", "out", Rung{Name: "2160p", Height: 2160, Profile: tt.profile}, TranscodeOptions{VideoCodec: tt.codec, SegmentDuration: 6})
+		got := ""
+		if i := slices.Index(args, "-pix_fmt"); i >= 0 {
+			got = args[i+1]
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: -pix_fmt %q, want %q (%s)", tt.codec, tt.profile, got, tt.want, strings.Join(args, " "))
+		}
+	}
+}
+
+func TestPresetRejectsUnknownProfile(t *testing.T) {
+	preset := &Preset{VideoCodec: "libx264", Rungs: []Rung{{Name: "720p", Height: 720, Profile: "main10"}}}
+	if err := preset.validate(); err == nil {
+		t.Error("expected main10 to be rejected for libx264")
+	}
+}
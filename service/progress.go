@@ -0,0 +1,155 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type RenditionProgress struct {
+	Rendition  string  `json:"rendition"`
+	Percent    float64 `json:"percent"`
+	ETASeconds float64 `json:"eta_seconds"`
+}
+
+type ProgressSnapshot struct {
+	JobID      string              `json:"job_id"`
+	Renditions []RenditionProgress `json:"renditions"`
+	Done       bool                `json:"done"`
+}
+
+// ProgressTracker holds the progress of every rendition of a single job.
+// A tracker is created for each StartTranscoding call and closed when the
+// call returns, so concurrent jobs never share or leak progress entries.
+type ProgressTracker struct {
+	jobID string
+	now   func() time.Time
+
+	mu          sync.Mutex
+	order       []string
+	percent     map[string]float64
+	started     map[string]time.Time // First progress of each rendition, which may wait for a free encoder
+	subscribers map[chan ProgressSnapshot]struct{}
+	closed      bool
+}
+
+func NewProgressTracker(jobID string) *ProgressTracker {
+	return &ProgressTracker{
+		jobID:       jobID,
+		now:         time.Now,
+		percent:     make(map[string]float64),
+		started:     make(map[string]time.Time),
+		subscribers: make(map[chan ProgressSnapshot]struct{}),
+	}
+}
+
+// JobID returns the job this tracker reports on
+func (t *ProgressTracker) JobID() string {
+	return t.jobID
+}
+
+// Update records the completion percentage of a rendition and notifies subscribers
+func (t *ProgressTracker) Update(rendition string, percent float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	if _, ok := t.percent[rendition]; !ok {
+		t.order = append(t.order, rendition)
+	}
+	t.percent[rendition] = min(max(percent, 0), 100)
+	if _, ok := t.started[rendition]; !ok && percent > 0 {
+		t.started[rendition] = t.now()
+	}
+	t.notify()
+}
+
+// Snapshot returns the current progress of all renditions in registration order
+func (t *ProgressTracker) Snapshot() ProgressSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// Subscribe returns a channel that always holds the latest snapshot, and a
+// function to stop listening. The channel is closed when the tracker closes.
+func (t *ProgressTracker) Subscribe() (<-chan ProgressSnapshot, func()) {
+	ch := make(chan ProgressSnapshot, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		ch <- t.snapshot()
+		close(ch)
+		return ch, func() {}
+	}
+	t.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if _, ok := t.subscribers[ch]; ok {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close marks the job as finished, delivers a final snapshot and releases subscribers
+func (t *ProgressTracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.notify()
+
+	for ch := range t.subscribers {
+		close(ch)
+	}
+	t.subscribers = nil
+}
+
+// snapshot estimates each rendition's ETA from the time since its own first
+// progress, so renditions queued behind others are not penalized for the wait
+func (t *ProgressTracker) snapshot() ProgressSnapshot {
+	now := t.now()
+
+	renditions := make([]RenditionProgress, 0, len(t.order))
+	for _, rendition := range t.order {
+		percent := t.percent[rendition]
+		eta := 0.0
+		if started, ok := t.started[rendition]; ok {
+			eta = estimateETA(percent, now.Sub(started).Seconds())
+		}
+		renditions = append(renditions, RenditionProgress{
+			Rendition:  rendition,
+			Percent:    percent,
+			ETASeconds: eta,
+		})
+	}
+
+	return ProgressSnapshot{
+		JobID:      t.jobID,
+		Renditions: renditions,
+		Done:       t.closed,
+	}
+}
+
+// notify replaces whatever snapshot a subscriber has not consumed yet, so slow
+// readers always see the latest state instead of blocking updates
+func (t *ProgressTracker) notify() {
+	snapshot := t.snapshot()
+	for ch := range t.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- snapshot
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestTracker returns a tracker whose clock advance moves forward
+func newTestTracker() (*ProgressTracker, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+	tracker := NewProgressTracker("job")
+	tracker.now = func() time.Time { return now }
+	return tracker, func(d time.Duration) { now = now.Add(d) }
+}
+
+func renditionOf(t *testing.T, snapshot ProgressSnapshot, name string) RenditionProgress {
+	t.Helper()
+	for _, rendition := range snapshot.Renditions {
+		if rendition.Rendition == name {
+			return rendition
+		}
+	}
+	t.Fatalf("rendition %s missing from %+v", name, snapshot)
+	return RenditionProgress{}
+}
+
+func TestTrackerETAStartsAtFirstProgress(t *testing.T) {
+	tracker, advance := newTestTracker()
+	tracker.Update("1080p", 0)
+	tracker.Update("720p", 0)
+	tracker.Update("360p", 0)
+
+	// 1080p and 720p hold both encoder slots while 360p waits
+	tracker.Update("1080p", 1)
+	tracker.Update("720p", 1)
+	advance(100 * time.Second)
+	tracker.Update("1080p", 51)
+	tracker.Update("720p", 51)
+
+	if eta := renditionOf(t, tracker.Snapshot(), "360p").ETASeconds; eta != 0 {
+		t.Errorf("expected no ETA before the first progress, got %v", eta)
+	}
+
+	tracker.Update("360p", 1)
+	advance(10 * time.Second)
+	tracker.Update("360p", 21)
+
+	snapshot := tracker.Snapshot()
+	// 20% in 10s leaves 80% for 40s; measured from the job start it would be 110s * 79/21
+	if eta := renditionOf(t, snapshot, "360p").ETASeconds; eta < 37 || eta > 43 {
+		t.Errorf("expected an ETA of about 40s for 360p, got %v", eta)
+	}
+	if eta := renditionOf(t, snapshot, "1080p").ETASeconds; eta < 95 || eta > 125 {
+		t.Errorf("expected an ETA of about 105s for 1080p, got %v", eta)
+	}
+}
+
+func TestTrackerUpdate(t *testing.T) {
+	tracker, _ := newTestTracker()
+	tracker.Update("720p", 150)
+	tracker.Update("360p", -5)
+	tracker.Update("720p", 100)
+
+	snapshot := tracker.Snapshot()
+	if snapshot.JobID != "job" || snapshot.Done {
+		t.Errorf("unexpected snapshot header %+v", snapshot)
+	}
+	if len(snapshot.Renditions) != 2 || snapshot.Renditions[0].Rendition != "720p" || snapshot.Renditions[1].Rendition != "360p" {
+		t.Fatalf("expected renditions in registration order, got %+v", snapshot.Renditions)
+	}
+	if p := snapshot.Renditions[0].Percent; p != 100 {
+		t.Errorf("expected 720p to be clamped to 100, got %v", p)
+	}
+	if p := snapshot.Renditions[1].Percent; p != 0 {
+		t.Errorf("expected 360p to be clamped to 0, got %v", p)
+	}
+	if eta := snapshot.Renditions[0].ETASeconds; eta != 0 {
+		t.Errorf("expected no ETA for a finished rendition, got %v", eta)
+	}
+}
+
+func TestTrackerSubscribers(t *testing.T) {
+	tracker, _ := newTestTracker()
+	updates, unsubscribe := tracker.Subscribe()
+	defer unsubscribe()
+
+	tracker.Update("720p", 10)
+	tracker.Update("720p", 20)
+	// Unread snapshots are replaced, so the subscriber only sees the latest
+	if p := renditionOf(t, <-updates, "720p").Percent; p != 20 {
+		t.Errorf("expected the latest snapshot, got %v%%", p)
+	}
+
+	tracker.Close()
+	final, ok := <-updates
+	if !ok || !final.Done {
+		t.Fatalf("expected a final snapshot, got %+v (open: %v)", final, ok)
+	}
+	if _, ok := <-updates; ok {
+		t.Error("expected the channel to be closed")
+	}
+
+	// Updates after Close are ignored and late subscribers get the final state
+	tracker.Update("720p", 50)
+	late, _ := tracker.Subscribe()
+	if snapshot := <-late; !snapshot.Done || renditionOf(t, snapshot, "720p").Percent != 20 {
+		t.Errorf("unexpected snapshot after close %+v", snapshot)
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// idPattern restricts identifiers that become path segments (video IDs,
+// rendition folders) to a portable, shell- and URL-safe alphabet
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+var extPattern = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// reservedNames cannot be used as file names on Windows hosts or shares
+var reservedNames = map[string]struct{}{
+	"con": {}, "prn": {}, "aux": {}, "nul": {},
+	"com1": {}, "com2": {}, "com3": {}, "com4": {}, "com5": {}, "com6": {}, "com7": {}, "com8": {}, "com9": {},
+	"lpt1": {}, "lpt2": {}, "lpt3": {}, "lpt4": {}, "lpt5": {}, "lpt6": {}, "lpt7": {}, "lpt8": {}, "lpt9": {},
+}
+
+// ValidateID rejects identifiers that are unsafe to use as a single path segment
+func ValidateID(id string) error {
+	if !idPattern.MatchString(id) || strings.Contains(id, "..") {
+		return fmt.Errorf("unsafe identifier %q", id)
+	}
+
+	base := strings.ToLower(strings.SplitN(id, ".", 2)[0])
+	if _, reserved := reservedNames[base]; reserved {
+		return fmt.Errorf("reserved identifier %q", id)
+	}
+	return nil
+}
+
+// SafeJoin joins identifiers below base, validating each of them and
+// guaranteeing the result cannot escape base
+func SafeJoin(base string, ids ...string) (string, error) {
+	for _, id := range ids {
+		if err := ValidateID(id); err != nil {
+			return "", err
+		}
+	}
+
+	joined := filepath.Join(append([]string{base}, ids...)...)
+	if !WithinDir(base, joined) {
+		return "", fmt.Errorf("path %q escapes %q", joined, base)
+	}
+	return joined, nil
+}
+
+// WithinDir reports whether path resolves to base or a location below it
+func WithinDir(base, path string) bool {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absBase, absPath)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// SanitizeFilename reduces a client-supplied file name to a printable base
+// name, dropping directories, control and bidi-override characters
+func SanitizeFilename(name string) string {
+	// Clients on Windows may send backslash-separated paths
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.TrimSpace(stripUnprintable(name))
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	return truncateRunes(name, 255)
+}
+
+// SanitizeTitle removes unprintable characters from a user-supplied title
+func SanitizeTitle(title string) string {
+	return truncateRunes(strings.TrimSpace(stripUnprintable(title)), 200)
+}
+
+// sanitizeAttribute cleans a title like SanitizeTitle and replaces double
+// quotes, so it can be written as a quoted manifest attribute such as NAME
+func sanitizeAttribute(title string) string {
+	return strings.ReplaceAll(SanitizeTitle(title), `"`, "'")
+}
+
+// Slugify turns name into an identifier that passes ValidateID by replacing
+// every character outside the identifier alphabet with a dash. It returns ""
+// if nothing usable is left, such as for reserved names.
+func Slugify(name string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r < 128 && (r == '-' || r == '_' || r == '.' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+	slug = strings.TrimLeft(slug, "-_.")
+	slug = truncateRunes(slug, 128)
+
+	if ValidateID(slug) != nil {
+		return ""
+	}
+	return slug
+}
+
+// SafeExt returns the lower-cased extension of name, or "" if it contains
+// anything other than ASCII letters and digits
+func SafeExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !extPattern.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
+
+func stripUnprintable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == unicode.ReplacementChar || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) > limit {
+		return string(runes[:limit])
+	}
+	return s
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		valid bool
+	}{
+		{"uuid", "0b7e2a52-6a5b-4f7e-9d8c-2f5b1f0c9e11", true},
+		{"rendition folder", "720p", true},
+		{"dots inside", "key-001.bin", true},
+		{"empty", "", false},
+		{"dot", ".", false},
+		{"parent", "..", false},
+		{"embedded parent", "a..b", false},
+		{"traversal", "a/../b", false},
+		{"slash", "a/b", false},
+		{"backslash", `a\b`, false},
+		{"absolute path", "/etc/passwd", false},
+		{"windows absolute path", `C:\Windows`, false},
+		{"leading dot", ".hidden", false},
+		{"leading dash", "-rf", false},
+		{"nul", "a\x00b", false},
+		{"newline", "a\nb", false},
+		{"tab", "a\tb", false},
+		{"space", "a b", false},
+		{"unicode letter", "vidéo", false},
+		{"cyrillic lookalike", "vіdeo", false},
+		{"rtl override", "a\u202eb", false},
+		{"zero width space", "a\u200bb", false},
+		{"fullwidth dot", "a\uff0e\uff0eb", false},
+		{"con", "CON", false},
+		{"con lower case", "con", false},
+		{"nul with extension", "nul.txt", false},
+		{"lpt1", "LPT1", false},
+		{"com9 mixed case", "CoM9.log", false},
+		{"reserved name prefix", "console", true},
+		{"lpt10", "lpt10", true},
+		{"128 characters", strings.Repeat("a", 128), true},
+		{"129 characters", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateID(tt.id)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateID(%q) = %v, want nil", tt.id, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ValidateID(%q) = nil, want an error", tt.id)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	base := t.TempDir()
+
+	tests := []struct {
+		name string
+		ids  []string
+		want string // Relative to base; empty when the join must fail
+	}{
+		{"no identifiers", nil, "."},
+		{"single", []string{"video"}, "video"},
+		{"nested", []string{"tenant", "video", "720p"}, filepath.Join("tenant", "video", "720p")},
+		{"parent", []string{".."}, ""},
+		{"parent after valid", []string{"video", ".."}, ""},
+		{"traversal in one id", []string{"a/../../etc"}, ""},
+		{"absolute", []string{"/etc"}, ""},
+		{"backslash traversal", []string{`..\..\etc`}, ""},
+		{"nul", []string{"video\x00"}, ""},
+		{"reserved", []string{"video", "aux"}, ""},
+		{"empty id", []string{"video", ""}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SafeJoin(base, tt.ids...)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("SafeJoin(%q) = %q, want an error", tt.ids, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("SafeJoin(%q) failed: %v", tt.ids, err)
+			}
+			if want := filepath.Join(base, tt.want); got != want {
+				t.Errorf("SafeJoin(%q) = %q, want %q", tt.ids, got, want)
+			}
+			if !WithinDir(base, got) {
+				t.Errorf("SafeJoin(%q) = %q escapes %q", tt.ids, got, base)
+			}
+		})
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "output")
+
+	tests := []struct {
+		name   string
+		path   string
+		within bool
+	}{
+		{"base itself", base, true},
+		{"child", filepath.Join(base, "video", "master.m3u8"), true},
+		{"dotted child name", filepath.Join(base, "..video"), true},
+		{"cleaned back inside", filepath.Join(base, "video", "..", "other"), true},
+		{"parent", filepath.Join(base, ".."), false},
+		{"escape through parent", base + string(filepath.Separator) + filepath.Join("..", "keys"), false},
+		{"sibling sharing a prefix", base + "-other", false},
+		{"absolute elsewhere", filepath.Join(string(filepath.Separator), "etc", "passwd"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WithinDir(base, tt.path); got != tt.within {
+				t.Errorf("WithinDir(%q, %q) = %v, want %v", base, tt.path, got, tt.within)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"plain", "movie.mp4", "movie.mp4"},
+		{"unix path", "/home/user/movie.mp4", "movie.mp4"},
+		{"traversal", "../../etc/passwd", "passwd"},
+		{"windows path", `C:\Users\me\movie.mp4`, "movie.mp4"},
+		{"windows traversal", `..\..\movie.mp4`, "movie.mp4"},
+		{"only parent", "..", "upload"},
+		{"trailing slash", "movies/", "upload"},
+		{"empty", "", "upload"},
+		{"blank", "   ", "upload"},
+		{"nul", "mo\x00vie.mp4", "movie.mp4"},
+		{"control characters", "mo\x1bvie\r\n.mp4", "movie.mp4"},
+		{"delete", "movie\x7f.mp4", "movie.mp4"},
+		{"invalid utf-8", "mo\xffvie.mp4", "movie.mp4"},
+		{"rtl override", "movie\u202e4pm.exe", "movie4pm.exe"},
+		{"zero width joiner", "mo\u200dvie.mp4", "movie.mp4"},
+		{"unicode", "vidéo été.mp4", "vidéo été.mp4"},
+		{"cyrillic", "видео.mkv", "видео.mkv"},
+		{"arabic", "فيديو.mp4", "فيديو.mp4"},
+		{"hebrew", "סרט.mov", "סרט.mov"},
+		{"emoji", "🎬 trailer.mp4", "🎬 trailer.mp4"},
+		{"surrounding spaces", "  movie.mp4  ", "movie.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFilename(tt.filename); got != tt.want {
+				t.Errorf("SanitizeFilename(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameLength(t *testing.T) {
+	got := SanitizeFilename(strings.Repeat("é", 300) + ".mp4")
+	if n := utf8.RuneCountInString(got); n != 255 {
+		t.Errorf("expected 255 characters, got %d", n)
+	}
+	if !utf8.ValidString(got) {
+		t.Error("truncation split a character")
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"plain", "My Video", "My Video"},
+		{"trimmed", "  My Video \n", "My Video"},
+		{"control characters", "My\x00 Vi\x1bdeo", "My Video"},
+		{"rtl override", "\u202eoediV yM", "oediV yM"},
+		{"unicode", "Vidéo d'été 🎬", "Vidéo d'été 🎬"},
+		{"right to left script", "فيديو", "فيديو"},
+		{"slashes are kept", "Part 1/2", "Part 1/2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeTitle(tt.title); got != tt.want {
+				t.Errorf("SanitizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+
+	if n := utf8.RuneCountInString(SanitizeTitle(strings.Repeat("ж", 500))); n != 200 {
+		t.Errorf("expected titles to be cut to 200 characters, got %d", n)
+	}
+}
+
+func TestSanitizeAttribute(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"English", "English"},
+		{`Director's "Commentary"`, "Director's 'Commentary'"},
+		{"Dub\x00 \"FR\"\r\n", "Dub 'FR'"},
+		{"\u202eAudio", "Audio"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeAttribute(tt.title); got != tt.want {
+			t.Errorf("sanitizeAttribute(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestSafeExt(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"mp4", "movie.mp4", ".mp4"},
+		{"upper case", "MOVIE.MOV", ".mov"},
+		{"last extension", "movie.tar.mkv", ".mkv"},
+		{"none", "movie", ""},
+		{"trailing dot", "movie.", ""},
+		{"path separator", "movie.mp4/..", ""},
+		{"shell characters", "movie.mp4;rm", ""},
+		{"unicode", "movie.mp４", ""},
+		{"nul", "movie.mp4\x00", ""},
+		{"too long", "movie." + strings.Repeat("a", 11), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SafeExt(tt.filename); got != tt.want {
+				t.Errorf("SafeExt(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // Empty when no identifier can be derived
+	}{
+		{"plain", "trailer", "trailer"},
+		{"kept punctuation", "my_clip-v2.final", "my_clip-v2.final"},
+		{"spaces", "My Holiday Video", "My-Holiday-Video"},
+		{"leading punctuation", "._-clip", "clip"},
+		{"leading dash from replaced space", " clip", "clip"},
+		{"unicode", "vidéo été", "vid-o--t-"},
+		{"slashes", "a/b\\c", "a-b-c"},
+		{"control characters", "clip\x00\n", "clip--"},
+		{"rtl override", "clip\u202eexe", "clip-exe"},
+		{"long name is cut", strings.Repeat("a", 200), strings.Repeat("a", 128)},
+		{"empty", "", ""},
+		{"only symbols", "@@@", ""},
+		{"only unicode", "видео", ""},
+		{"parent", "..", ""},
+		{"embedded parent", "a..b", ""},
+		{"reserved", "CON", ""},
+		{"reserved with extension", "aux.final", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Slugify(tt.input)
+			if got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if got != "" && ValidateID(got) != nil {
+				t.Errorf("Slugify(%q) = %q is not a valid identifier", tt.input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SubtitleSource is a sidecar subtitle file supplied with a video
+type SubtitleSource struct {
+	FilePath string `json:"file_path"`
+	Language string `json:"language"` // ISO 639 code, "und" when unknown
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// SubtitleInfo describes a segmented WebVTT subtitle rendition
+type SubtitleInfo struct {
+	Track    int // Position in the subtitle group, from 1
+	Language string
+	Name     string
+	Default  bool
+	Forced   bool
+}
+
+// subtitleGroupID is the single HLS subtitle group referenced by every video variant
+const subtitleGroupID = "subs"
+
+// subtitleExtensions lists the accepted sidecar formats. SRT and WebVTT are
+// converted by ffmpeg, TTML by parseTTML.
+var subtitleExtensions = map[string]bool{
+	".srt":  true,
+	".vtt":  true,
+	".ttml": true,
+	".dfxp": true,
+}
+
+// textSubtitleCodecs are the embedded subtitle codecs that convert to WebVTT;
+// bitmap subtitles such as PGS or DVD subtitles are skipped
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"ass":      true,
+	"ssa":      true,
+	"mov_text": true,
+	"webvtt":   true,
+	"text":     true,
+}
+
+// IsSubtitleFile reports whether a file name has a supported subtitle extension
+func IsSubtitleFile(filename string) bool {
+	return subtitleExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// SubtitleFromFilename describes a stored sidecar, reading the language and
+// flags from the name it was supplied under, e.g. "movie.en.srt" or
+// "movie.spa.forced.vtt"
+func SubtitleFromFilename(filePath, filename string) SubtitleSource {
+	subtitle := SubtitleSource{FilePath: filePath, Language: "und"}
+
+	// Flags follow the language, which follows the base name
+	parts := strings.Split(strings.ToLower(strings.TrimSuffix(filename, filepath.Ext(filename))), ".")
+	for ; len(parts) > 1; parts = parts[:len(parts)-1] {
+		switch last := parts[len(parts)-1]; last {
+		case "forced":
+			subtitle.Forced = true
+		case "default":
+			subtitle.Default = true
+		default:
+			subtitle.Language = normalizeLanguage(last)
+			return subtitle
+		}
+	}
+	return subtitle
+}
+
+// subtitleTrack is a subtitle stream to convert, either a sidecar file or a
+// text stream embedded in the source
+type subtitleTrack struct {
+	FolderName string
+	Path       string
+	Stream     int  // Stream index in Path, -1 for sidecar files
+	Sidecar    bool // Sidecars are supplied explicitly, so failing to convert them fails the job
+	Info       SubtitleInfo
+}
+
+// probeSubtitleTracks lists the text subtitle streams of the source
+func probeSubtitleTracks(inputFile string) ([]subtitleTrack, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "s",
+		"-show_entries", "stream=index,codec_name:stream_tags=language,title:stream_disposition=default,forced",
+		"-of", "json",
+		inputFile,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			Index       int               `json:"index"`
+			CodecName   string            `json:"codec_name"`
+			Tags        map[string]string `json:"tags"`
+			Disposition map[string]int    `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	var tracks []subtitleTrack
+	for _, stream := range probe.Streams {
+		if !textSubtitleCodecs[stream.CodecName] {
+			slog.Warn("Skipping subtitle stream that cannot be converted to WebVTT", "index", stream.Index, "codec", stream.CodecName)
+			continue
+		}
+		tracks = append(tracks, subtitleTrack{
+			Path:   inputFile,
+			Stream: stream.Index,
+			Info: SubtitleInfo{
+				Language: normalizeLanguage(stream.Tags["language"]),
+				Name:     sanitizeAttribute(stream.Tags["title"]),
+				Default:  stream.Disposition["default"] == 1,
+				Forced:   stream.Disposition["forced"] == 1,
+			},
+		})
+	}
+	return tracks, nil
+}
+
+// subtitleTracks combines the embedded streams and sidecars into one group,
+// naming and numbering them. HLS allows a single default per group.
+func subtitleTracks(embedded []subtitleTrack, sidecars []SubtitleSource) []subtitleTrack {
+	tracks := append([]subtitleTrack{}, embedded...)
+	for _, sidecar := range sidecars {
+		tracks = append(tracks, subtitleTrack{
+			Path:    sidecar.FilePath,
+			Stream:  -1,
+			Sidecar: true,
+			Info: SubtitleInfo{
+				Language: normalizeLanguage(sidecar.Language),
+				Default:  sidecar.Default,
+				Forced:   sidecar.Forced,
+			},
+		})
+	}
+
+	hasDefault := false
+	names := make(map[string]int)
+	for i := range tracks {
+		info := &tracks[i].Info
+		info.Track = i + 1
+		if info.Default && hasDefault {
+			info.Default = false
+		}
+		hasDefault = hasDefault || info.Default
+
+		if info.Name == "" {
+			info.Name = languageName(info.Language, i+1)
+			if info.Forced {
+				info.Name += " (Forced)"
+			}
+		}
+		info.Name = uniqueName(names, info.Name)
+
+		tracks[i].FolderName = fmt.Sprintf("subtitles-%d-%s", i+1, info.Language)
+	}
+	return tracks
+}
+
+// uniqueName appends a counter to names already used in the same HLS group,
+// which requires distinct NAME attributes
+func uniqueName(seen map[string]int, name string) string {
+	seen[name]++
+	if seen[name] == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s (%d)", name, seen[name])
+}
+
+// writeSubtitleRendition converts a subtitle track to WebVTT and splits it into
+// segments of segmentDuration seconds, keeping the complete track as
+// subtitles.vtt. Every segment maps its cue times onto the video timeline,
+// which starts at startTime seconds.
+func (s *transcodeService) writeSubtitleRendition(videoID string, track subtitleTrack, duration, startTime float64, segmentDuration int) (VariantInfo, error) {
+	outputDir, err := s.createDirectory(videoID, track.FolderName)
+	if err != nil {
+		return VariantInfo{}, err
+	}
+
+	cues, err := loadSubtitleCues(track, filepath.Join(outputDir, "subtitles.vtt"))
+	if err != nil && track.Sidecar {
+		return VariantInfo{}, Permanent(fmt.Errorf("subtitle %s: %v", filepath.Base(track.Path), err))
+	}
+	if err != nil {
+		return VariantInfo{}, err
+	}
+
+	total := duration
+	if total <= 0 {
+		for _, cue := range cues {
+			total = max(total, cue.End)
+		}
+	}
+	segmentCount := max(int(math.Ceil(total/float64(segmentDuration))), 1)
+	timestampMap := fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", int64(math.Round(startTime*90000)))
+
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", segmentDuration)
+
+	for i := 0; i < segmentCount; i++ {
+		start := float64(i * segmentDuration)
+		end := float64((i + 1) * segmentDuration)
+		if i == segmentCount-1 {
+			end = max(total, start+0.001)
+		}
+
+		// Cues spanning a boundary are repeated in both segments, as HLS expects
+		var segment strings.Builder
+		segment.WriteString("WEBVTT\n" + timestampMap + "\n")
+		for _, cue := range cues {
+			if cue.Start < end && cue.End > start {
+				segment.WriteString("\n" + cue.String())
+			}
+		}
+
+		name := fmt.Sprintf("sub_%03d.vtt", i)
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(segment.String()), 0644); err != nil {
+			return VariantInfo{}, err
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.6f,\n%s\n", end-start, name)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := os.WriteFile(filepath.Join(outputDir, "index.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		return VariantInfo{}, err
+	}
+
+	info := track.Info
+	return VariantInfo{FolderName: track.FolderName, Subtitle: &info}, nil
+}
+
+// writeSubtitles segments every subtitle track along the video timeline of
+// the first rendition. Embedded streams that fail to convert are skipped.
+func (s *transcodeService) writeSubtitles(videoID string, tracks []subtitleTrack, rungs []Rung, duration float64, segmentDuration int, results chan VariantInfo) error {
+	if len(tracks) == 0 || len(rungs) == 0 {
+		return nil
+	}
+
+	playlistPath, err := SafeJoin(s.storage.OutputDir, videoID, rungs[0].Name, "index.m3u8")
+	if err != nil {
+		return err
+	}
+	startTime, err := probeStartTime(playlistPath)
+	if err != nil {
+		slog.Warn("Failed to determine the video start time, assuming 0", "videoID", videoID, "error", err)
+	}
+
+	for _, track := range tracks {
+		variant, err := s.writeSubtitleRendition(videoID, track, duration, startTime, segmentDuration)
+		if err != nil && !track.Sidecar {
+			slog.Warn("Skipping embedded subtitle stream", "folderName", track.FolderName, "error", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		results <- variant
+	}
+	return nil
+}
+
+// loadSubtitleCues reads the cues of a track, converting it to WebVTT at vttPath
+func loadSubtitleCues(track subtitleTrack, vttPath string) ([]vttCue, error) {
+	if track.Sidecar && isTTML(track.Path) {
+		data, err := os.ReadFile(track.Path)
+		if err != nil {
+			return nil, err
+		}
+		cues, err := parseTTML(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTML: %v", err)
+		}
+		return cues, os.WriteFile(vttPath, []byte(formatWebVTT(cues)), 0644)
+	}
+
+	args := []string{"-y", "-v", "error", "-i", track.Path}
+	if track.Stream >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:%d", track.Stream))
+	}
+	args = append(args, "-vn", "-an", "-codec:s", "webvtt", "-f", "webvtt", vttPath)
+
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to convert subtitles: %v: %s", err, out)
+	}
+
+	data, err := os.ReadFile(vttPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseWebVTT(string(data))
+}
+
+func isTTML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".ttml" || ext == ".dfxp"
+}
+
+// probeStartTime returns the presentation time at which a rendition starts,
+// which subtitle segments are aligned to
+func probeStartTime(playlistPath string) (float64, error) {
+	args := []string{
+		"-v", "error",
+		"-show_entries", "format=start_time",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		playlistPath,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	value := strings.TrimSpace(string(out))
+	if value == "" || value == "N/A" {
+		return 0, nil
+	}
+	var start float64
+	if _, err := fmt.Sscanf(value, "%g", &start); err != nil {
+		return 0, fmt.Errorf("failed to parse start time %q", value)
+	}
+	return start, nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+)
+
+const (
+	thumbsDir      = "thumbs"
+	posterName     = "poster.jpg"
+	thumbnailCount = 10
+	thumbnailWidth = 320
+	posterHeight   = 720
+)
+
+// ImageSet lists the still images of a video, relative to its output directory
+type ImageSet struct {
+	Poster     string
+	Thumbnails []string
+}
+
+// posterFilter drops frames that are mostly black before letting ffmpeg's
+// thumbnail filter pick the most representative of the remaining ones
+var posterFilter = fmt.Sprintf(
+	"blackframe=amount=0:threshold=32,metadata=mode=select:key=lavfi.blackframe.pblack:value=90:function=less,thumbnail=n=120,scale=-2:'min(%d,ih)'",
+	posterHeight)
+
+// GenerateImages writes a poster frame and evenly spaced thumbnails of
+// inputFile into the thumbs directory of the video
+func (s *transcodeService) GenerateImages(videoID, inputFile string, duration float64) (*ImageSet, error) {
+	dir, err := SafeJoin(s.storage.OutputDir, videoID, thumbsDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	images := &ImageSet{Poster: path.Join(thumbsDir, posterName)}
+	if err := generatePoster(inputFile, filepath.Join(dir, posterName), duration); err != nil {
+		return nil, err
+	}
+
+	if duration <= 0 {
+		return images, nil
+	}
+	for i := 0; i < thumbnailCount; i++ {
+		name := fmt.Sprintf("thumb_%03d.jpg", i+1)
+		// Sample the middle of each of thumbnailCount equal slices
+		at := duration * (float64(i) + 0.5) / thumbnailCount
+
+		args := []string{
+			"-y", "-v", "error",
+			"-ss", fmt.Sprintf("%.3f", at),
+			"-i", inputFile,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+			"-q:v", "4",
+			filepath.Join(dir, name),
+		}
+		if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+			slog.Warn("Failed to extract thumbnail", "videoID", videoID, "at", at, "error", err, "output", string(out))
+			continue
+		}
+		images.Thumbnails = append(images.Thumbnails, path.Join(thumbsDir, name))
+	}
+
+	return images, nil
+}
+
+// generatePoster picks a non-black frame from the first part of the video,
+// falling back to the very first frame when every candidate is black
+func generatePoster(inputFile, posterPath string, duration float64) error {
+	// Skip intros and fades, but stay well inside short clips
+	start := min(duration*0.1, 60)
+
+	args := []string{
+		"-y", "-v", "error",
+		"-ss", fmt.Sprintf("%.3f", start),
+		"-i", inputFile,
+		"-vf", posterFilter,
+		"-frames:v", "1",
+		"-q:v", "3",
+		posterPath,
+	}
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err == nil {
+		if info, statErr := os.Stat(posterPath); statErr == nil && info.Size() > 0 {
+			return nil
+		}
+	}
+	slog.Warn("No non-black poster frame found, using the first frame", "input", inputFile, "error", err, "output", string(out))
+
+	args = []string{
+		"-y", "-v", "error",
+		"-i", inputFile,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", posterHeight),
+		"-q:v", "3",
+		posterPath,
+	}
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to extract poster: %v: %s", err, out)
+	}
+	return nil
+}
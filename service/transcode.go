@@ -3,11 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
+	"go-transcoder/config"
 	"io"
 	"mime/multipart"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,32 +21,67 @@ import (
 )
 
 type VariantInfo struct {
-	Height     int
-	Width      int
-	Bandwidth  int
-	FolderName string
+	Height           int
+	Width            int
+	Bandwidth        int // Peak segment bitrate
+	AverageBandwidth int
+	FrameRate        float64
+	FolderName       string
+	Codecs           string
+	SegmentFormat    SegmentFormat
+	IFrameBandwidth  int           // Peak bitrate of the I-frame playlist, 0 when it is missing
+	Audio            *AudioInfo    // Set for audio-only renditions
+	Subtitle         *SubtitleInfo // Set for WebVTT subtitle renditions
 }
 
 type TranscodeService interface {
-	GenerateMasterPlaylist(videoName string, results chan VariantInfo) error
+	GenerateMasterPlaylist(videoID string, variants []VariantInfo) error
+	GenerateDashManifest(videoID string, variants []VariantInfo) error
 	StoreFile(file multipart.File, header *multipart.FileHeader) (string, error)
 	GetVariantMetadata(segmentPath string) (width int, height int, bitrate int, err error)
-	StartTranscoding(inputFile, videoName string, resolutions map[string]int, duration float64) (chan VariantInfo, error)
+	StartTranscoding(tracker *ProgressTracker, inputFile, videoID string, rungs []Rung, duration float64, opts TranscodeOptions) (chan VariantInfo, error)
+	GenerateImages(videoID, inputFile string, duration float64) (*ImageSet, error)
+	GenerateTrickplay(videoID, inputFile string, duration float64, opts Trickplay) (string, error)
+	ForTenant(tenant string) (TranscodeService, error)
 }
 
 type transcodeService struct {
 	progressUI ProgressUIService
+	storage    config.StorageConfig
 }
 
-func NewTranscodeService(progressUI ProgressUIService) TranscodeService {
+func NewTranscodeService(progressUI ProgressUIService, storage config.StorageConfig) TranscodeService {
 	return &transcodeService{
 		progressUI: progressUI,
+		storage:    storage,
 	}
 }
 
+// ForTenant returns a transcoder that writes below the tenant's own output
+// directory. Videos without a tenant keep the shared layout. Keys stay in the
+// shared key directory, where the API-assigned video IDs cannot collide.
+func (s *transcodeService) ForTenant(tenant string) (TranscodeService, error) {
+	if tenant == "" {
+		return s, nil
+	}
+	outputDir, err := SafeJoin(s.storage.OutputDir, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := *s
+	scoped.storage.OutputDir = outputDir
+	return &scoped, nil
+}
+
 // GenerateMasterPlaylist creates the master playlist file for HLS streaming
-func (s *transcodeService) GenerateMasterPlaylist(videoName string, results chan VariantInfo) error {
-	masterPath := filepath.Join("output", videoName, "master.m3u8")
+func (s *transcodeService) GenerateMasterPlaylist(videoID string, variants []VariantInfo) error {
+	videoDir, err := SafeJoin(s.storage.OutputDir, videoID)
+	if err != nil {
+		return err
+	}
+
+	masterPath := filepath.Join(videoDir, "master.m3u8")
 	f, err := os.Create(masterPath)
 	if err != nil {
 		return err
@@ -56,22 +93,42 @@ func (s *transcodeService) GenerateMasterPlaylist(videoName string, results chan
 		return err
 	}
 
-	resultsSlice := make([]VariantInfo, 0)
-
-	for v := range results {
-		resultsSlice = append(resultsSlice, v)
-	}
-
-	resultsSlice = sortVariantsByHeight(resultsSlice)
+	resultsSlice, audio, subtitles := splitVariants(sortVariantsByHeight(variants))
 
 	if len(resultsSlice) == 0 {
 		slog.Error("No variant info available to generate master playlist")
 		return fmt.Errorf("no variant info available to generate master playlist")
 	}
 
-	for _, variant := range resultsSlice {
-		line1 := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n",
-			variant.Bandwidth, variant.Width, variant.Height)
+	groups := groupAudio(audio)
+	for _, group := range groups {
+		for _, rendition := range group.Renditions {
+			line := fmt.Sprintf("#EXT-X-MEDIA:%s\n", mediaAttributes(group.ID, rendition))
+			if _, err := f.WriteString(line); err != nil {
+				slog.Error("Failed to write to master playlist", "error", err)
+				return err
+			}
+		}
+	}
+
+	sort.SliceStable(subtitles, func(i, j int) bool {
+		return subtitles[i].Subtitle.Track < subtitles[j].Subtitle.Track
+	})
+	for _, rendition := range subtitles {
+		line := fmt.Sprintf("#EXT-X-MEDIA:%s\n", subtitleMediaAttributes(rendition))
+		if _, err := f.WriteString(line); err != nil {
+			slog.Error("Failed to write to master playlist", "error", err)
+			return err
+		}
+	}
+	subtitleGroup := ""
+	if len(subtitles) > 0 {
+		subtitleGroup = subtitleGroupID
+	}
+
+	for i, variant := range resultsSlice {
+		group := audioGroupFor(groups, i, len(resultsSlice))
+		line1 := fmt.Sprintf("#EXT-X-STREAM-INF:%s\n", streamInfAttributes(variant, group, subtitleGroup))
 		line2 := fmt.Sprintf("%s/index.m3u8\n", variant.FolderName)
 
 		if _, err := f.WriteString(line1); err != nil {
@@ -84,17 +141,116 @@ func (s *transcodeService) GenerateMasterPlaylist(videoName string, results chan
 		}
 	}
 
+	// I-frame streams let players scrub and fast-forward without loading whole segments
+	for _, variant := range resultsSlice {
+		if variant.IFrameBandwidth == 0 {
+			continue
+		}
+
+		attrs := fmt.Sprintf("BANDWIDTH=%d,RESOLUTION=%dx%d", variant.IFrameBandwidth, variant.Width, variant.Height)
+		if codecs := videoCodecs(variant.Codecs); codecs != "" {
+			attrs += fmt.Sprintf(",CODECS=\"%s\"", codecs)
+		}
+		line := fmt.Sprintf("#EXT-X-I-FRAME-STREAM-INF:%s,URI=\"%s/%s\"\n", attrs, variant.FolderName, iframePlaylistName)
+
+		if _, err := f.WriteString(line); err != nil {
+			slog.Error("Failed to write to master playlist", "error", err)
+			return err
+		}
+	}
+
 	return nil
 }
 
+// streamInfAttributes lists the EXT-X-STREAM-INF attributes of a video
+// variant played with the given audio and subtitle groups, leaving out values
+// that could not be probed. Bitrates and codecs cover both video and audio.
+func streamInfAttributes(variant VariantInfo, audio *audioGroup, subtitleGroup string) string {
+	bandwidth, average, codecs := variant.Bandwidth, variant.AverageBandwidth, variant.Codecs
+	if audio != nil {
+		bandwidth += audio.Bandwidth
+		if average > 0 && audio.AverageBandwidth > 0 {
+			average += audio.AverageBandwidth
+		} else {
+			average = 0
+		}
+		if codecs != "" && audio.Codecs != "" {
+			codecs += "," + audio.Codecs
+		} else {
+			codecs = ""
+		}
+	}
+
+	attrs := []string{fmt.Sprintf("BANDWIDTH=%d", bandwidth)}
+	if average > 0 {
+		attrs = append(attrs, fmt.Sprintf("AVERAGE-BANDWIDTH=%d", average))
+	}
+	if codecs != "" {
+		attrs = append(attrs, fmt.Sprintf("CODECS=\"%s\"", codecs))
+	}
+	attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", variant.Width, variant.Height))
+	if variant.FrameRate > 0 {
+		attrs = append(attrs, fmt.Sprintf("FRAME-RATE=%.3f", variant.FrameRate))
+	}
+	if audio != nil {
+		attrs = append(attrs, fmt.Sprintf("AUDIO=\"%s\"", audio.ID))
+	}
+	if subtitleGroup != "" {
+		attrs = append(attrs, fmt.Sprintf("SUBTITLES=\"%s\"", subtitleGroup))
+	}
+	// No captions are embedded, and without this players assume CEA-608 may be present
+	attrs = append(attrs, "CLOSED-CAPTIONS=NONE")
+	return strings.Join(attrs, ",")
+}
+
+// mediaAttributes lists the EXT-X-MEDIA attributes of an audio rendition
+func mediaAttributes(groupID string, rendition VariantInfo) string {
+	audio := rendition.Audio
+
+	attrs := []string{"TYPE=AUDIO", fmt.Sprintf("GROUP-ID=\"%s\"", groupID)}
+	if tag := languageTag(audio.Language); tag != "" {
+		attrs = append(attrs, fmt.Sprintf("LANGUAGE=\"%s\"", tag))
+	}
+	return strings.Join(append(attrs,
+		fmt.Sprintf("NAME=\"%s\"", audio.Name),
+		"DEFAULT="+yesNo(audio.Default),
+		"AUTOSELECT=YES",
+		fmt.Sprintf("CHANNELS=\"%d\"", audio.Channels),
+		fmt.Sprintf("URI=\"%s/index.m3u8\"", rendition.FolderName),
+	), ",")
+}
+
+// subtitleMediaAttributes lists the EXT-X-MEDIA attributes of a subtitle rendition
+func subtitleMediaAttributes(rendition VariantInfo) string {
+	subtitle := rendition.Subtitle
+	attrs := []string{"TYPE=SUBTITLES", fmt.Sprintf("GROUP-ID=\"%s\"", subtitleGroupID)}
+	if tag := languageTag(subtitle.Language); tag != "" {
+		attrs = append(attrs, fmt.Sprintf("LANGUAGE=\"%s\"", tag))
+	}
+	return strings.Join(append(attrs,
+		fmt.Sprintf("NAME=\"%s\"", subtitle.Name),
+		"DEFAULT="+yesNo(subtitle.Default),
+		"AUTOSELECT=YES",
+		"FORCED="+yesNo(subtitle.Forced),
+		fmt.Sprintf("URI=\"%s/index.m3u8\"", rendition.FolderName),
+	), ",")
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "YES"
+	}
+	return "NO"
+}
+
 // StoreFile saves the uploaded file to a temporary location and returns the file path
 func (s *transcodeService) StoreFile(file multipart.File, header *multipart.FileHeader) (string, error) {
-	if err := os.MkdirAll("uploads", 0755); err != nil {
+	if err := os.MkdirAll(s.storage.UploadsDir, 0755); err != nil {
 		return "", err
 	}
 
-	fileName := uuid.New().String() + filepath.Ext(header.Filename)
-	filePath := filepath.Join("uploads", fileName)
+	fileName := uuid.New().String() + SafeExt(header.Filename)
+	filePath := filepath.Join(s.storage.UploadsDir, fileName)
 	out, err := os.Create(filePath)
 	if err != nil {
 		slog.Error("Failed to create file", "error", err)
@@ -205,30 +361,70 @@ func (s *transcodeService) GetVariantMetadata(segmentPath string) (width int, he
 	return width, height, bitrate, nil
 }
 
-// StartTranscoding initiates the transcoding process for the given input file
-func (s *transcodeService) StartTranscoding(inputFile, videoName string, resolutions map[string]int, duration float64) (chan VariantInfo, error) {
+// StartTranscoding initiates the transcoding process for the given input file,
+// reporting progress to tracker and closing it once every rendition finished.
+// Video rungs are encoded without audio; every audio track of the source is
+// encoded once per audio bitrate into its own audio-only rendition, and text
+// subtitles, embedded or sidecar, become segmented WebVTT renditions. With
+// opts.Encryption set, the audio and video segments are then encrypted with AES-128.
+// Failures caused by the input itself are returned as PermanentError.
+func (s *transcodeService) StartTranscoding(tracker *ProgressTracker, inputFile, videoID string, rungs []Rung, duration float64, opts TranscodeOptions) (chan VariantInfo, error) {
 	g, ctx := errgroup.WithContext(context.Background())
 	sem := make(chan struct{}, 2)
-	results := make(chan VariantInfo, len(resolutions))
+	defer tracker.Close()
 
-	mu.Lock()
-	for folder := range resolutions {
-		allProgress[folder] = 0.0
+	if err := ValidateID(videoID); err != nil {
+		return nil, Permanent(err)
+	}
+	if err := checkSource(inputFile); err != nil {
+		return nil, err
+	}
+	if opts.SegmentFormat == "" {
+		opts.SegmentFormat = SegmentTS
+	}
+	if opts.VideoCodec == "" {
+		opts.VideoCodec = "libx264"
+	}
+	if opts.SegmentDuration == 0 {
+		opts.SegmentDuration = 10
+	}
+	if len(opts.AudioBitrates) == 0 {
+		opts.AudioBitrates = []int{128000}
+	}
+
+	tracks, err := probeAudioTracks(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	audio := audioRenditions(tracks, opts.AudioBitrates)
+
+	embedded, err := probeSubtitleTracks(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	subtitles := subtitleTracks(embedded, opts.Subtitles)
+	results := make(chan VariantInfo, len(rungs)+len(audio)+len(subtitles))
+
+	for _, rung := range sortRungsByHeight(rungs) {
+		tracker.Update(rung.Name, 0)
+		fmt.Println()
+	}
+	for _, rendition := range audio {
+		tracker.Update(rendition.FolderName, 0)
 		fmt.Println()
 	}
-	mu.Unlock()
 
 	uiCtx, cancelUI := context.WithCancel(ctx)
-	go s.progressUI.StartUI(uiCtx, len(resolutions))
+	go s.progressUI.StartUI(uiCtx, tracker)
 
-	for folder, height := range resolutions {
-		folderName := folder
-		targetHeight := height
+	for _, rung := range rungs {
+		folderName := rung.Name
+		targetHeight := rung.Height
 		g.Go(func() error {
 
 			sem <- struct{}{}
 			defer func() { <-sem }()
-			err := createDirectory(videoName, folderName)
+			outputDir, err := s.createDirectory(videoID, folderName)
 			if err != nil {
 				slog.Error("Failed to create directory", "folderName", folderName, "error", err)
 				return fmt.Errorf("error creating directory for %s: %v", folderName, err)
@@ -236,49 +432,66 @@ func (s *transcodeService) StartTranscoding(inputFile, videoName string, resolut
 
 			args := getFFmpegArgs(
 				inputFile,
-				filepath.Join("output", videoName, folderName),
-				targetHeight,
+				outputDir,
+				rung,
+				opts,
 			)
 
-			cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+			if err := s.runFFmpeg(ctx, tracker, folderName, args, duration); err != nil {
+				return err
+			}
 
-			stdErr, err := cmd.StderrPipe()
+			probePath, err := renditionProbePath(outputDir, opts.SegmentFormat)
 			if err != nil {
-				slog.Error("Failed to get stderr pipe", "folderName", folderName, "error", err)
-				return fmt.Errorf("failed to get stderr pipe: %v", err)
+				slog.Error("No segments found after transcoding", "folderName", folderName, "error", err)
+				return fmt.Errorf("metadata error: %v", err)
 			}
 
-			if err := cmd.Start(); err != nil {
-				slog.Error("Failed to start ffmpeg", "folderName", folderName, "error", err)
-				return fmt.Errorf("failed to start ffmpeg for %s: %v", folderName, err)
+			width, _, bitrate, err := s.GetVariantMetadata(probePath)
+			if err != nil {
+				slog.Error("Failed to get variant metadata", "folderName", folderName, "error", err)
+				return fmt.Errorf("failed to get variant metadata for %s: %v", folderName, err)
 			}
 
-			go s.progressUI.MonitorProgress(folderName, stdErr, duration)
-
-			if err := cmd.Wait(); err != nil {
-				slog.Error("ffmpeg command failed", "folderName", folderName, "error", err)
-				return fmt.Errorf("ffmpeg failed for %s: %v", folderName, err)
+			codecs, err := probeCodecs(probePath)
+			if err != nil {
+				slog.Warn("Failed to determine codecs", "folderName", folderName, "error", err)
 			}
 
-			time.Sleep(500 * time.Millisecond)
-			pattern := filepath.Join("output", videoName, folderName, "*.ts")
-			matches, err := filepath.Glob(pattern)
-			if err != nil || len(matches) == 0 {
-				slog.Error("No segments found after transcoding", "folderName", folderName, "pattern", pattern, "error", err)
-				return fmt.Errorf("metadata error: no segments found in %s (checked %s)", folderName, pattern)
+			// The stream bitrate reported by ffprobe describes a single segment,
+			// so the advertised bitrates are measured across all of them
+			peak, average, err := segmentBitrates(outputDir)
+			if err != nil {
+				slog.Warn("Failed to measure segment bitrates", "folderName", folderName, "error", err)
+				peak = bitrate
 			}
 
-			width, _, bitrate, err := s.GetVariantMetadata(matches[0])
+			frameRate, err := probeFrameRate(probePath)
 			if err != nil {
-				slog.Error("Failed to get variant metadata", "folderName", folderName, "error", err)
-				return fmt.Errorf("failed to get variant metadata for %s: %v", folderName, err)
+				slog.Warn("Failed to determine frame rate", "folderName", folderName, "error", err)
+			}
+
+			// Players fall back to regular segments for scrubbing, so a missing
+			// I-frame playlist is not fatal. Encrypted videos have none: a byte
+			// range into a segment padded as a whole cannot be decrypted on its own.
+			iframeBandwidth := 0
+			if opts.Encryption == nil {
+				iframeBandwidth, err = writeIFramePlaylist(outputDir, opts.SegmentFormat)
+				if err != nil {
+					slog.Warn("Failed to write I-frame playlist", "folderName", folderName, "error", err)
+				}
 			}
 
 			results <- VariantInfo{
-				Height:     targetHeight,
-				Width:      width,
-				Bandwidth:  bitrate,
-				FolderName: folderName,
+				Height:           targetHeight,
+				Width:            width,
+				Bandwidth:        peak,
+				AverageBandwidth: average,
+				FrameRate:        frameRate,
+				FolderName:       folderName,
+				Codecs:           codecs,
+				SegmentFormat:    opts.SegmentFormat,
+				IFrameBandwidth:  iframeBandwidth,
 			}
 
 			return nil
@@ -287,38 +500,211 @@ func (s *transcodeService) StartTranscoding(inputFile, videoName string, resolut
 
 	}
 
+	for _, rendition := range audio {
+		folderName := rendition.FolderName
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			outputDir, err := s.createDirectory(videoID, folderName)
+			if err != nil {
+				slog.Error("Failed to create directory", "folderName", folderName, "error", err)
+				return fmt.Errorf("error creating directory for %s: %v", folderName, err)
+			}
+
+			if err := s.runFFmpeg(ctx, tracker, folderName, getAudioArgs(inputFile, outputDir, rendition, opts), duration); err != nil {
+				return err
+			}
+
+			probePath, err := renditionProbePath(outputDir, opts.SegmentFormat)
+			if err != nil {
+				slog.Error("No segments found after transcoding", "folderName", folderName, "error", err)
+				return fmt.Errorf("metadata error: %v", err)
+			}
+
+			codecs, err := probeCodecs(probePath)
+			if err != nil {
+				slog.Warn("Failed to determine codecs", "folderName", folderName, "error", err)
+			}
+
+			peak, average, err := segmentBitrates(outputDir)
+			if err != nil {
+				slog.Warn("Failed to measure segment bitrates", "folderName", folderName, "error", err)
+				peak = rendition.Info.Bitrate
+			}
+
+			info := rendition.Info
+			results <- VariantInfo{
+				Bandwidth:        peak,
+				AverageBandwidth: average,
+				FolderName:       folderName,
+				Codecs:           codecs,
+				SegmentFormat:    opts.SegmentFormat,
+				Audio:            &info,
+			}
+			return nil
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		cancelUI()
 		return nil, err
 	}
 
+	// Subtitle segments are aligned to the encoded video, so they come last
+	if err := s.writeSubtitles(videoID, subtitles, rungs, duration, opts.SegmentDuration, results); err != nil {
+		cancelUI()
+		return nil, err
+	}
+
+	// Segments are encrypted last, once every rendition has been probed and indexed
+	if opts.Encryption != nil {
+		folderNames := make([]string, 0, len(rungs)+len(audio))
+		for _, rung := range rungs {
+			folderNames = append(folderNames, rung.Name)
+		}
+		for _, rendition := range audio {
+			folderNames = append(folderNames, rendition.FolderName)
+		}
+		if err := s.encryptRenditions(videoID, folderNames, *opts.Encryption); err != nil {
+			cancelUI()
+			return nil, err
+		}
+	}
+
 	CloseResultsChannel(results, cancelUI)
 
 	return results, nil
 }
 
-// createDirectory ensures the output directory for a given resolution exists
-func createDirectory(fileName, resolution string) error {
-	targetDir := filepath.Join("output", fileName, resolution)
+// runFFmpeg runs a single rendition encode, reporting its progress under folderName
+func (s *transcodeService) runFFmpeg(ctx context.Context, tracker *ProgressTracker, folderName string, args []string, duration float64) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
-	err := os.MkdirAll(targetDir, 0755)
+	stdErr, err := cmd.StderrPipe()
 	if err != nil {
-		slog.Error("Failed to create directory", "targetDir", targetDir, "error", err)
-		return fmt.Errorf("failed to create directory %s: %v", targetDir, err)
+		slog.Error("Failed to get stderr pipe", "folderName", folderName, "error", err)
+		return fmt.Errorf("failed to get stderr pipe: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		slog.Error("Failed to start ffmpeg", "folderName", folderName, "error", err)
+		return fmt.Errorf("failed to start ffmpeg for %s: %v", folderName, err)
 	}
 
+	go s.progressUI.MonitorProgress(tracker, folderName, stdErr, duration)
+
+	if err := cmd.Wait(); err != nil {
+		slog.Error("ffmpeg command failed", "folderName", folderName, "error", err)
+		return fmt.Errorf("ffmpeg failed for %s: %v", folderName, err)
+	}
 	return nil
 }
 
-func getFFmpegArgs(inputFile, outputDir string, height int) []string {
-	return []string{
+// renditionProbePath returns the file to probe for a rendition's stream
+// metadata. Fragments carry no codec configuration, so CMAF renditions are
+// probed via their init segment.
+func renditionProbePath(outputDir string, format SegmentFormat) (string, error) {
+	time.Sleep(500 * time.Millisecond)
+	pattern := filepath.Join(outputDir, "*"+format.Extension())
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) == 0 {
+		return "", fmt.Errorf("no segments found in %s (checked %s)", filepath.Base(outputDir), pattern)
+	}
+
+	if format == SegmentFMP4 {
+		return filepath.Join(outputDir, fmp4InitName), nil
+	}
+	return matches[0], nil
+}
+
+// createDirectory ensures an empty output directory for a given resolution
+// exists and returns it, refusing identifiers that would escape the output
+// root. Emptying it keeps a retried job from picking up stale segments.
+func (s *transcodeService) createDirectory(videoID, resolution string) (string, error) {
+	targetDir, err := SafeJoin(s.storage.OutputDir, videoID, resolution)
+	if err != nil {
+		slog.Error("Refusing unsafe output directory", "videoID", videoID, "resolution", resolution, "error", err)
+		return "", err
+	}
+
+	if err := os.RemoveAll(targetDir); err != nil {
+		return "", fmt.Errorf("failed to clear directory %s: %v", targetDir, err)
+	}
+
+	err = os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		slog.Error("Failed to create directory", "targetDir", targetDir, "error", err)
+		return "", fmt.Errorf("failed to create directory %s: %v", targetDir, err)
+	}
+
+	return targetDir, nil
+}
+
+// fmp4InitName is the CMAF initialization segment written next to each rendition's fragments
+const fmp4InitName = "init.mp4"
+
+func getFFmpegArgs(inputFile, outputDir string, rung Rung, opts TranscodeOptions) []string {
+	args := []string{
+		"-y",
 		"-i", inputFile,
-		"-vf", fmt.Sprintf("scale=-2:%d", height),
-		"-codec:v", "libx264",
-		"-codec:a", "aac",
-		"-hls_time", "10",
+		"-vf", fmt.Sprintf("scale=-2:%d", rung.Height),
+		"-codec:v", opts.VideoCodec,
+	}
+
+	if rung.Profile != "" {
+		args = append(args, "-profile:v", rung.Profile)
+	}
+	// A 10-bit source cannot be encoded with an 8-bit profile and vice versa
+	if pixFmt := pixelFormat(opts.VideoCodec, rung.Profile); pixFmt != "" {
+		args = append(args, "-pix_fmt", pixFmt)
+	}
+	if rung.Level != "" {
+		if opts.VideoCodec == "libx265" {
+			args = append(args, "-x265-params", "level-idc="+rung.Level)
+		} else {
+			args = append(args, "-level:v", rung.Level)
+		}
+	}
+	if rung.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(rung.CRF))
+	}
+	if rung.VideoBitrate > 0 {
+		args = append(args, "-b:v", strconv.Itoa(rung.VideoBitrate))
+	}
+	if rung.MaxBitrate > 0 {
+		args = append(args, "-maxrate", strconv.Itoa(rung.MaxBitrate), "-bufsize", strconv.Itoa(rung.BufferSize))
+	}
+	if opts.VideoCodec == "libx265" {
+		// Apple players only accept HEVC tagged as hvc1
+		args = append(args, "-tag:v", "hvc1")
+	}
+
+	// Keyframes on every segment boundary keep renditions switchable at any
+	// segment. Audio is encoded once into separate renditions.
+	args = append(args,
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", opts.SegmentDuration),
+		"-an", "-sn", "-dn",
+	)
+	return append(args, getHLSArgs(outputDir, opts)...)
+}
+
+// getHLSArgs selects the HLS muxer settings shared by video and audio renditions
+func getHLSArgs(outputDir string, opts TranscodeOptions) []string {
+	args := []string{
+		"-hls_time", strconv.Itoa(opts.SegmentDuration),
 		"-hls_playlist_type", "vod",
-		"-hls_segment_filename", filepath.Join(outputDir, "seg_%03d.ts"),
-		filepath.Join(outputDir, "index.m3u8"),
 	}
+
+	if opts.SegmentFormat == SegmentFMP4 {
+		// ffmpeg adds the #EXT-X-MAP tag pointing at the init segment
+		args = append(args,
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", fmp4InitName,
+		)
+	}
+
+	return append(args,
+		"-hls_segment_filename", filepath.Join(outputDir, "seg_%03d"+opts.SegmentFormat.Extension()),
+		filepath.Join(outputDir, "index.m3u8"),
+	)
 }
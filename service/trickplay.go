@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	trickplayDir  = "trickplay"
+	trickplayVTT  = "thumbnails.vtt"
+	spritePattern = "sprite_%03d.jpg"
+)
+
+// GenerateTrickplay renders scrubbing preview frames onto sprite sheets and
+// writes a WebVTT track mapping each time range to its tile. It returns the
+// track's path relative to the video's output directory.
+func (s *transcodeService) GenerateTrickplay(videoID, inputFile string, duration float64, opts Trickplay) (string, error) {
+	if duration <= 0 {
+		return "", errors.New("trickplay needs the source duration")
+	}
+
+	dir, err := SafeJoin(s.storage.OutputDir, videoID, trickplayDir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	args := []string{
+		"-y", "-v", "error",
+		"-i", inputFile,
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:-2,tile=%dx%d", opts.Interval, opts.Width, opts.Columns, opts.Rows),
+		"-q:v", "5",
+		filepath.Join(dir, spritePattern),
+	}
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to render sprites: %v: %s", err, out)
+	}
+
+	sheets, err := filepath.Glob(filepath.Join(dir, "sprite_*.jpg"))
+	if err != nil || len(sheets) == 0 {
+		return "", errors.New("no sprite sheets were rendered")
+	}
+
+	// Every sheet holds a full grid, so the tile size follows from the first one
+	sheetWidth, sheetHeight, err := imageSize(filepath.Join(dir, fmt.Sprintf(spritePattern, 1)))
+	if err != nil {
+		return "", err
+	}
+	tileWidth, tileHeight := sheetWidth/opts.Columns, sheetHeight/opts.Rows
+
+	perSheet := opts.Columns * opts.Rows
+	frames := min(int(math.Ceil(duration/float64(opts.Interval))), len(sheets)*perSheet)
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i := 0; i < frames; i++ {
+		start := float64(i * opts.Interval)
+		end := min(float64((i+1)*opts.Interval), duration)
+		tile := i % perSheet
+
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end),
+			fmt.Sprintf(spritePattern, i/perSheet+1),
+			(tile%opts.Columns)*tileWidth, (tile/opts.Columns)*tileHeight, tileWidth, tileHeight)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, trickplayVTT), []byte(vtt.String()), 0644); err != nil {
+		return "", err
+	}
+	return path.Join(trickplayDir, trickplayVTT), nil
+}
+
+// imageSize probes the pixel dimensions of an image
+func imageSize(imagePath string) (int, int, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height",
+		"-of", "csv=p=0:s=x",
+		imagePath,
+	}
+
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	width, height, ok := strings.Cut(strings.TrimSpace(string(out)), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected ffprobe output %q", out)
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil {
+		return 0, 0, err
+	}
+	h, err := strconv.Atoi(height)
+	if err != nil {
+		return 0, 0, err
+	}
+	return w, h, nil
+}
+
+// vttTimestamp formats seconds as a WebVTT HH:MM:SS.mmm timestamp
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
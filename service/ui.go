@@ -9,7 +9,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -18,17 +17,14 @@ type Progress struct {
 	Percent float64
 }
 
-var mu sync.Mutex
-
-var allProgress = make(map[string]float64)
 var timeRegex = regexp.MustCompile(`time=(\d{2}:\d{2}:\d{2}\.\d{2})`)
 
 type progressUI struct{}
 
 type ProgressUIService interface {
-	StartUI(ctx context.Context, folderCount int)
+	StartUI(ctx context.Context, tracker *ProgressTracker)
 	GetDuration(inputPath string) (float64, error)
-	MonitorProgress(folderName string, stderrPipe io.ReadCloser, totalDuration float64)
+	MonitorProgress(tracker *ProgressTracker, folderName string, stderrPipe io.ReadCloser, totalDuration float64)
 	TimeToSeconds(timeStr string) (float64, error)
 }
 
@@ -36,18 +32,18 @@ func NewProgressUI() ProgressUIService {
 	return &progressUI{}
 }
 
-// StartUI begins the progress UI that updates the progress bars periodically
-func (p *progressUI) StartUI(ctx context.Context, folderCount int) {
+// StartUI begins the progress UI that updates the job's progress bars periodically
+func (p *progressUI) StartUI(ctx context.Context, tracker *ProgressTracker) {
 	ticker := time.NewTicker(200 * time.Millisecond) // Smooth updates
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			drawBars(folderCount)
+			drawBars(tracker.Snapshot())
 			return
 		case <-ticker.C:
-			drawBars(folderCount)
+			drawBars(tracker.Snapshot())
 		}
 	}
 }
@@ -92,18 +88,18 @@ func (p *progressUI) TimeToSeconds(timeStr string) (float64, error) {
 	return h*3600 + m*60 + s, nil
 }
 
-// MonitorProgress reads ffmpeg stderr output to track progress for a specific folder
-func (p *progressUI) MonitorProgress(folderName string, stderrPipe io.ReadCloser, totalDuration float64) {
+// MonitorProgress reads ffmpeg stderr output to track progress for a specific
+// folder. Without a known duration no percentage can be computed, so the
+// output is only drained.
+func (p *progressUI) MonitorProgress(tracker *ProgressTracker, folderName string, stderrPipe io.ReadCloser, totalDuration float64) {
 	scanner := bufio.NewScanner(stderrPipe)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if strings.Contains(line, "time=") {
+		if totalDuration > 0 && strings.Contains(line, "time=") {
 			timeStr := extractTime(line)
 			currentSec, _ := p.TimeToSeconds(timeStr)
 
-			mu.Lock()
-			allProgress[folderName] = (currentSec / totalDuration) * 100
-			mu.Unlock()
+			tracker.Update(folderName, (currentSec/totalDuration)*100)
 		}
 	}
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -20,6 +21,25 @@ func sortVariantsByHeight(variants []VariantInfo) []VariantInfo {
 	return sorted
 }
 
+func sortRungsByHeight(rungs []Rung) []Rung {
+	sorted := make([]Rung, len(rungs))
+	copy(sorted, rungs)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Height < sorted[j].Height
+	})
+	return sorted
+}
+
+// CollectVariants drains the results of StartTranscoding, ordered by height
+func CollectVariants(results chan VariantInfo) []VariantInfo {
+	variants := make([]VariantInfo, 0, len(results))
+	for v := range results {
+		variants = append(variants, v)
+	}
+	return sortVariantsByHeight(variants)
+}
+
 func CloseResultsChannel(results chan VariantInfo, cancel context.CancelFunc) {
 	close(results)
 	cancel()
@@ -33,14 +53,12 @@ func extractTime(line string) string {
 	return ""
 }
 
-func drawBars(folderCount int) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	fmt.Printf("\033[%dA", folderCount)
+func drawBars(snapshot ProgressSnapshot) {
+	fmt.Printf("\033[%dA", len(snapshot.Renditions))
 
 	// 2. Print each bar
-	for folder, pct := range allProgress {
+	for _, rendition := range snapshot.Renditions {
+		folder, pct := rendition.Rendition, rendition.Percent
 		// Calculate how many '#' to show for a bar of length 20
 		barLength := 20
 		filled := min(int(pct/100*float64(barLength)), barLength)
@@ -51,3 +69,21 @@ func drawBars(folderCount int) {
 		fmt.Printf("\r\033[K[%-7s] %s %.2f%%\n", folder, bar, pct)
 	}
 }
+
+// estimateETA extrapolates the remaining seconds from the elapsed time and the
+// completed percentage, returning 0 until there is progress to extrapolate from
+func estimateETA(percent, elapsedSeconds float64) float64 {
+	if percent <= 0 || percent >= 100 {
+		return 0
+	}
+	return elapsedSeconds * (100 - percent) / percent
+}
+
+// VideoURL returns the public URL of a file below a video's output
+// directory, which is nested in its tenant's directory when it has one
+func VideoURL(tenant, videoID, rel string) string {
+	if tenant != "" {
+		return "/videos/" + tenant + "/" + videoID + "/" + rel
+	}
+	return "/videos/" + videoID + "/" + rel
+}
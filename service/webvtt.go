@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// vttCue is a single WebVTT cue; times are in seconds
+type vttCue struct {
+	Start    float64
+	End      float64
+	Settings string // Positioning settings following the timing line
+	Text     string
+}
+
+func (c vttCue) String() string {
+	timing := vttTimestamp(c.Start) + " --> " + vttTimestamp(c.End)
+	if c.Settings != "" {
+		timing += " " + c.Settings
+	}
+	return timing + "\n" + c.Text + "\n"
+}
+
+func formatWebVTT(cues []vttCue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n" + cue.String())
+	}
+	return b.String()
+}
+
+// parseWebVTT reads the cues of a WebVTT file, skipping NOTE, STYLE and
+// REGION blocks as well as cue identifiers
+func parseWebVTT(data string) ([]vttCue, error) {
+	data = strings.TrimPrefix(data, "\uFEFF")
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	if !strings.HasPrefix(data, "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+
+	var cues []vttCue
+	for _, block := range strings.Split(data, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) > 0 && !strings.Contains(lines[0], "-->") {
+			lines = lines[1:] // Header, comment or cue identifier
+		}
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			continue
+		}
+
+		startValue, rest, _ := strings.Cut(lines[0], "-->")
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid cue timing %q", lines[0])
+		}
+		start, err := parseVTTTimestamp(strings.TrimSpace(startValue))
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseVTTTimestamp(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		cues = append(cues, vttCue{
+			Start:    start,
+			End:      end,
+			Settings: strings.Join(fields[1:], " "),
+			Text:     strings.Join(lines[1:], "\n"),
+		})
+	}
+	return cues, nil
+}
+
+// parseVTTTimestamp parses "hh:mm:ss.ttt" or "mm:ss.ttt"
+func parseVTTTimestamp(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	seconds := 0.0
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// ttmlTiming holds the parameters TTML time expressions depend on
+type ttmlTiming struct {
+	frameRate float64
+	tickRate  float64
+}
+
+// parseTTML extracts the paragraphs of a TTML (or DFXP) document as cues.
+// Styling and region positioning are dropped; <br/> becomes a line break.
+func parseTTML(data []byte) ([]vttCue, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	timing := ttmlTiming{frameRate: 30, tickRate: 1}
+
+	var cues []vttCue
+	var current *vttCue
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tt":
+				for _, attr := range t.Attr {
+					value, err := strconv.ParseFloat(attr.Value, 64)
+					if err != nil || value <= 0 {
+						continue
+					}
+					switch attr.Name.Local {
+					case "frameRate":
+						timing.frameRate = value
+					case "tickRate":
+						timing.tickRate = value
+					}
+				}
+			case "p":
+				cue, err := timing.paragraphTimes(t.Attr)
+				if err != nil {
+					return nil, err
+				}
+				current = &cue
+				text.Reset()
+			case "br":
+				if current != nil {
+					text.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			// Line breaks in the markup are plain whitespace; only <br/> breaks lines
+			if current != nil {
+				text.WriteString(strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(string(t)))
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" && current != nil {
+				current.Text = escapeVTT(collapseLines(text.String()))
+				if current.Text != "" && current.End > current.Start {
+					cues = append(cues, *current)
+				}
+				current = nil
+			}
+		}
+	}
+
+	if len(cues) == 0 {
+		return nil, errors.New("no timed paragraphs found")
+	}
+	return cues, nil
+}
+
+// paragraphTimes reads the begin and end (or dur) attributes of a <p>
+func (t ttmlTiming) paragraphTimes(attrs []xml.Attr) (vttCue, error) {
+	var cue vttCue
+	var begin, end, dur string
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "begin":
+			begin = attr.Value
+		case "end":
+			end = attr.Value
+		case "dur":
+			dur = attr.Value
+		}
+	}
+	if begin == "" || (end == "" && dur == "") {
+		return cue, errors.New("paragraph without begin and end times")
+	}
+
+	var err error
+	if cue.Start, err = t.parse(begin); err != nil {
+		return cue, err
+	}
+	if end != "" {
+		cue.End, err = t.parse(end)
+	} else {
+		var length float64
+		length, err = t.parse(dur)
+		cue.End = cue.Start + length
+	}
+	return cue, err
+}
+
+// parse converts a TTML clock time ("01:02:03.500" or "01:02:03:12" with
+// frames) or offset time ("12.5s", "1500ms", "90f", "10000000t") to seconds
+func (t ttmlTiming) parse(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ":") {
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			return 0, fmt.Errorf("invalid TTML time %q", value)
+		}
+		seconds, err := parseVTTTimestamp(strings.Join(parts[:3], ":"))
+		if err != nil {
+			return 0, err
+		}
+		if len(parts) == 4 {
+			frames, err := strconv.ParseFloat(parts[3], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid TTML time %q", value)
+			}
+			seconds += frames / t.frameRate
+		}
+		return seconds, nil
+	}
+
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"ms", 0.001},
+		{"h", 3600},
+		{"m", 60},
+		{"s", 1},
+		{"f", 1 / t.frameRate},
+		{"t", 1 / t.tickRate},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid TTML time %q", value)
+			}
+			return n * unit.scale, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid TTML time %q", value)
+}
+
+// collapseLines applies XML whitespace handling to each line of a paragraph
+func collapseLines(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeVTT escapes the characters WebVTT reserves in cue text
+func escapeVTT(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
+	"go-transcoder/service"
+	"log"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Headers carried by retried jobs
+const (
+	headerAttempt = "x-attempt"  // 1-based attempt the message is delivered for
+	headerRetryAt = "x-retry-at" // RFC 3339 time the retry becomes due
+	headerError   = "x-error"    // Failure that caused the retry
+)
+
+// maxRetryDelay keeps backoffs below Kafka's 24h poll interval limit
+const maxRetryDelay = 12 * time.Hour
+
+// DeadLetter is published on the dead-letter topic for jobs that failed
+// permanently or exhausted their attempts
+type DeadLetter struct {
+	JobID     string          `json:"job_id,omitempty"`
+	VideoID   string          `json:"video_id,omitempty"`
+	Reason    string          `json:"reason"`
+	Permanent bool            `json:"permanent"`
+	Attempts  int             `json:"attempts"`
+	FailedAt  time.Time       `json:"failed_at"`
+	Job       json.RawMessage `json:"job"` // The original message, verbatim
+}
+
+// retryTopic names the delayed topic that holds jobs after their n-th failed attempt
+func retryTopic(jobsTopic string, n int) string {
+	return fmt.Sprintf("%s-retry-%d", jobsTopic, n)
+}
+
+// retryDelay doubles base for every attempt after the first
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// attemptOf returns the attempt a message is delivered for, 1 for fresh jobs
+func attemptOf(msg queue.Message) int {
+	if attempt, err := strconv.Atoi(msg.Header(headerAttempt)); err == nil && attempt > 0 {
+		return attempt
+	}
+	return 1
+}
+
+// retryAtOf returns when a retried message becomes due, or the zero time if it is due now
+func retryAtOf(msg queue.Message) time.Time {
+	at, err := time.Parse(time.RFC3339Nano, msg.Header(headerRetryAt))
+	if err != nil {
+		return time.Time{}
+	}
+	return at
+}
+
+// relayRetries moves jobs from one retry topic back onto the jobs topic once
+// their backoff has elapsed. Every message in a retry topic waits the same
+// delay, so blocking on the oldest one never holds back a message that is due.
+func (w *workerService) relayRetries(n int) {
+	topic := retryTopic(w.cfg.Queue.JobsTopic, n)
+	delay := retryDelay(w.cfg.Queue.RetryBackoff, n)
+
+	sub, err := w.queue.Subscribe(topic, w.cfg.Queue.GroupID+"."+topic, queue.SubscribeOptions{MaxProcessing: delay + time.Minute})
+	if err != nil {
+		log.Fatalf("Failed to subscribe to %s: %s", topic, err)
+	}
+	defer sub.Close()
+
+	for {
+		delivery, err := sub.Receive(context.Background())
+		if errors.Is(err, queue.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("Retry consumer error", "topic", topic, "error", err)
+			continue
+		}
+
+		if wait := time.Until(retryAtOf(delivery.Message)); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		if err := w.queue.Publish(w.cfg.Queue.JobsTopic, delivery.Message); err != nil {
+			slog.Error("Failed to requeue job", "topic", topic, "error", err)
+			redeliver(delivery)
+			continue
+		}
+
+		if err := delivery.Ack(); err != nil {
+			slog.Error("Failed to acknowledge message", "error", err)
+		}
+	}
+}
+
+// handleFailure schedules a failed job for another attempt or, when the error
+// is permanent or the attempts are exhausted, moves it to the dead-letter topic
+func (w *workerService) handleFailure(msg queue.Message, job queue.TranscodeJob, attempt int, cause error) error {
+	maxAttempts := w.cfg.Queue.MaxAttempts
+	if service.IsPermanent(cause) || attempt >= maxAttempts {
+		slog.Error("Job failed, moving to dead-letter topic", "JobID", job.JobID, "attempt", attempt, "permanent", service.IsPermanent(cause), "error", cause)
+		if err := w.deadLetter(msg, job, attempt, cause); err != nil {
+			return err
+		}
+		w.setState(job.JobID, jobstore.StateFailed, cause)
+		return nil
+	}
+
+	delay := retryDelay(w.cfg.Queue.RetryBackoff, attempt)
+	retry := queue.Message{
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: map[string]string{
+			headerAttempt: strconv.Itoa(attempt + 1),
+			headerRetryAt: time.Now().Add(delay).UTC().Format(time.RFC3339Nano),
+			headerError:   cause.Error(),
+		},
+	}
+	if err := w.queue.Publish(retryTopic(w.cfg.Queue.JobsTopic, attempt), retry); err != nil {
+		return err
+	}
+
+	slog.Warn("Job failed, scheduling retry", "JobID", job.JobID, "attempt", attempt, "delay", delay, "error", cause)
+	w.setState(job.JobID, jobstore.StateQueued, fmt.Errorf("attempt %d of %d failed, retrying in %s: %v", attempt, maxAttempts, delay, cause))
+	return nil
+}
+
+// deadLetter publishes the original message together with the failure reason
+func (w *workerService) deadLetter(msg queue.Message, job queue.TranscodeJob, attempts int, cause error) error {
+	original := json.RawMessage(msg.Value)
+	if !json.Valid(original) {
+		original, _ = json.Marshal(string(msg.Value))
+	}
+
+	value, err := json.Marshal(DeadLetter{
+		JobID:     job.JobID,
+		VideoID:   job.VideoID,
+		Reason:    cause.Error(),
+		Permanent: service.IsPermanent(cause),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+		Job:       original,
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.queue.Publish(w.cfg.Queue.DLQTopic, queue.Message{
+		Key:   msg.Key,
+		Value: value,
+		Headers: map[string]string{
+			headerAttempt: strconv.Itoa(attempts),
+			headerError:   cause.Error(),
+		},
+	})
+}
+
+// redeliver returns a delivery to its group after a short pause
+func redeliver(delivery *queue.Delivery) {
+	time.Sleep(5 * time.Second)
+	if err := delivery.Nack(); err != nil {
+		slog.Error("Failed to return message to the queue", "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"fmt"
+	"go-transcoder/infrastructure/queue"
+	"go-transcoder/service"
+)
+
+// validateJob guards the worker against messages that would make it read
+// outside the uploads directory, write outside the output directory, or that
+// request options the pipeline does not support. It returns the job's preset.
+func validateJob(job queue.TranscodeJob, presets *service.PresetCatalog, uploadsDir string) (*service.Preset, error) {
+	if err := service.ValidateID(job.VideoID); err != nil {
+		return nil, fmt.Errorf("invalid video id: %v", err)
+	}
+	if job.TenantID != "" {
+		if err := service.ValidateID(job.TenantID); err != nil {
+			return nil, fmt.Errorf("invalid tenant id: %v", err)
+		}
+	}
+	if !service.WithinDir(uploadsDir, job.FilePath) {
+		return nil, fmt.Errorf("source %q is outside the uploads directory", job.FilePath)
+	}
+	for _, subtitle := range job.Subtitles {
+		if !service.WithinDir(uploadsDir, subtitle.FilePath) || !service.IsSubtitleFile(subtitle.FilePath) {
+			return nil, fmt.Errorf("subtitle %q is not a subtitle file in the uploads directory", subtitle.FilePath)
+		}
+	}
+	format, err := service.ParseOutputFormat(job.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	if job.Encrypt {
+		if err := format.CheckEncryption(); err != nil {
+			return nil, err
+		}
+	}
+
+	segmentFormat, err := service.ParseSegmentFormat(job.SegmentFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	preset, err := presets.Get(job.Preset)
+	if err != nil {
+		return nil, err
+	}
+	if err := preset.CheckSegmentFormat(segmentFormat); err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
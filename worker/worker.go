@@ -0,0 +1,245 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-transcoder/config"
+	"go-transcoder/infrastructure/jobstore"
+	"go-transcoder/infrastructure/queue"
+	"go-transcoder/service"
+	"log"
+	"time"
+
+	"log/slog"
+)
+
+type workerService struct {
+	transcoder service.TranscodeService
+	presets    *service.PresetCatalog
+	queue      queue.Queue
+	jobs       jobstore.Store
+	cfg        *config.Config
+}
+
+type Worker interface {
+	Run()
+}
+
+func NewWorker(transcoder service.TranscodeService, presets *service.PresetCatalog, q queue.Queue, jobs jobstore.Store, cfg *config.Config) Worker {
+	return &workerService{
+		transcoder: transcoder,
+		presets:    presets,
+		queue:      q,
+		jobs:       jobs,
+		cfg:        cfg,
+	}
+}
+
+// Run consumes transcoding jobs until the queue is closed
+func (w *workerService) Run() {
+	// Long transcodes must not make the queue consider the worker dead and
+	// hand the job to another one while it is still running
+	sub, err := w.queue.Subscribe(w.cfg.Queue.JobsTopic, w.cfg.Queue.GroupID, queue.SubscribeOptions{MaxProcessing: w.cfg.Queue.MaxJobTime})
+	if err != nil {
+		log.Fatalf("Failed to subscribe to jobs: %s", err)
+	}
+	defer sub.Close()
+
+	for n := 1; n < w.cfg.Queue.MaxAttempts; n++ {
+		go w.relayRetries(n)
+	}
+
+	for {
+		delivery, err := sub.Receive(context.Background())
+		if errors.Is(err, queue.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("Consumer error", "error", err)
+			continue
+		}
+
+		// A job is only acknowledged once its outcome, including a scheduled
+		// retry or dead letter, has been recorded
+		if err := w.process(delivery); err != nil {
+			slog.Error("Failed to record job outcome, redelivering", "error", err)
+			redeliver(delivery)
+			continue
+		}
+
+		if err := delivery.Ack(); err != nil {
+			slog.Error("Failed to acknowledge message", "error", err)
+		}
+	}
+}
+
+// process runs a single delivery of a job, routing failures through handleFailure
+func (w *workerService) process(delivery *queue.Delivery) error {
+	attempt := attemptOf(delivery.Message)
+
+	var job queue.TranscodeJob
+	if err := json.Unmarshal(delivery.Value, &job); err != nil {
+		slog.Error("Failed to unmarshal message", "error", err)
+		return w.handleFailure(delivery.Message, job, attempt, service.Permanent(fmt.Errorf("malformed job: %v", err)))
+	}
+
+	preset, err := validateJob(job, w.presets, w.cfg.Storage.UploadsDir)
+	if err != nil {
+		slog.Error("Rejecting invalid job", "JobID", job.JobID, "error", err)
+		return w.handleFailure(delivery.Message, job, attempt, service.Permanent(err))
+	}
+	transcoder, err := w.transcoder.ForTenant(job.TenantID)
+	if err != nil {
+		return w.handleFailure(delivery.Message, job, attempt, service.Permanent(err))
+	}
+
+	slog.Info(">>> Processing Job", "VideoID", job.VideoID, "TenantID", job.TenantID, "Title", job.Title, "Preset", preset.Name, "Attempt", attempt, "FilePath", job.FilePath)
+	targets := preset.RungsFor(job.MaxHeight)
+
+	w.setState(job.JobID, jobstore.StateTranscoding, nil)
+	tracker := service.NewProgressTracker(job.JobID)
+	go w.reportProgress(tracker)
+
+	opts := preset.TranscodeOptions(service.SegmentFormat(job.SegmentFormat))
+	opts.Subtitles = job.Subtitles
+	if job.Encrypt {
+		opts.Encryption = &service.Encryption{KeyURL: w.cfg.Encryption.KeyURL, RotateEvery: w.cfg.Encryption.RotateEvery}
+	}
+	results, err := transcoder.StartTranscoding(tracker, job.FilePath, job.VideoID, targets, job.Duration, opts)
+	if err != nil {
+		slog.Error("Transcoding failed", "VideoID", job.VideoID, "error", err)
+		return w.handleFailure(delivery.Message, job, attempt, err)
+	}
+
+	w.setState(job.JobID, jobstore.StatePackaging, nil)
+	if err := w.packageOutputs(transcoder, job, results); err != nil {
+		slog.Error("Packaging failed", "VideoID", job.VideoID, "error", err)
+		return w.handleFailure(delivery.Message, job, attempt, err)
+	}
+	w.generateImages(transcoder, job)
+	if preset.Trickplay != nil {
+		w.generateTrickplay(transcoder, job, *preset.Trickplay)
+	}
+
+	slog.Info("SUCCESS: Finished", "VideoID", job.VideoID)
+	w.setState(job.JobID, jobstore.StateReady, nil)
+	return nil
+}
+
+// packageOutputs writes the manifests for every output format requested by the job
+func (w *workerService) packageOutputs(transcoder service.TranscodeService, job queue.TranscodeJob, results chan service.VariantInfo) error {
+	format, err := service.ParseOutputFormat(job.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	variants := service.CollectVariants(results)
+	if format.HasHLS() {
+		if err := transcoder.GenerateMasterPlaylist(job.VideoID, variants); err != nil {
+			return err
+		}
+	}
+	if format.HasDASH() {
+		if err := transcoder.GenerateDashManifest(job.VideoID, variants); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateImages records the poster and thumbnails of a job. They are not
+// needed for playback, so failures are logged without failing the job.
+func (w *workerService) generateImages(transcoder service.TranscodeService, job queue.TranscodeJob) {
+	images, err := transcoder.GenerateImages(job.VideoID, job.FilePath, job.Duration)
+	if err != nil {
+		slog.Warn("Failed to generate images", "VideoID", job.VideoID, "error", err)
+		return
+	}
+	if job.JobID == "" {
+		return
+	}
+
+	thumbnailURLs := make([]string, 0, len(images.Thumbnails))
+	for _, thumbnail := range images.Thumbnails {
+		thumbnailURLs = append(thumbnailURLs, service.VideoURL(job.TenantID, job.VideoID, thumbnail))
+	}
+
+	err = w.jobs.Update(job.JobID, func(record *jobstore.Job) {
+		record.PosterURL = service.VideoURL(job.TenantID, job.VideoID, images.Poster)
+		record.ThumbnailURLs = thumbnailURLs
+	})
+	if err != nil {
+		slog.Error("Failed to record images", "jobID", job.JobID, "error", err)
+	}
+}
+
+// generateTrickplay records the scrubbing preview track of a job, which is
+// optional in the same way as the poster and thumbnails
+func (w *workerService) generateTrickplay(transcoder service.TranscodeService, job queue.TranscodeJob, opts service.Trickplay) {
+	track, err := transcoder.GenerateTrickplay(job.VideoID, job.FilePath, job.Duration, opts)
+	if err != nil {
+		slog.Warn("Failed to generate trickplay sprites", "VideoID", job.VideoID, "error", err)
+		return
+	}
+	if job.JobID == "" {
+		return
+	}
+
+	err = w.jobs.Update(job.JobID, func(record *jobstore.Job) {
+		record.TrickplayURL = service.VideoURL(job.TenantID, job.VideoID, track)
+	})
+	if err != nil {
+		slog.Error("Failed to record trickplay track", "jobID", job.JobID, "error", err)
+	}
+}
+
+// setState records a job transition, tolerating messages enqueued without a job ID
+func (w *workerService) setState(jobID string, state jobstore.State, cause error) {
+	if jobID == "" {
+		return
+	}
+
+	errMsg := ""
+	if cause != nil {
+		errMsg = cause.Error()
+	}
+
+	if err := w.jobs.UpdateState(jobID, state, errMsg); err != nil {
+		slog.Error("Failed to update job state", "jobID", jobID, "state", state, "error", err)
+	}
+
+	queue.PublishProgress(w.queue, w.cfg.Queue.ProgressTopic, queue.ProgressEvent{
+		Type:  queue.EventTypeState,
+		JobID: jobID,
+		State: string(state),
+		Error: errMsg,
+	})
+}
+
+// reportProgress publishes a job's rendition progress at most once per second
+// until the tracker is closed
+func (w *workerService) reportProgress(tracker *service.ProgressTracker) {
+	if tracker.JobID() == "" {
+		return
+	}
+
+	updates, unsubscribe := tracker.Subscribe()
+	defer unsubscribe()
+
+	var lastPublished time.Time
+	for snapshot := range updates {
+		if !snapshot.Done && time.Since(lastPublished) < time.Second {
+			continue
+		}
+		lastPublished = time.Now()
+
+		queue.PublishProgress(w.queue, w.cfg.Queue.ProgressTopic, queue.ProgressEvent{
+			Type:       queue.EventTypeProgress,
+			JobID:      snapshot.JobID,
+			State:      string(jobstore.StateTranscoding),
+			Renditions: snapshot.Renditions,
+		})
+	}
+}